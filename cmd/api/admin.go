@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Return a list of users
+// @Description returns a page of users, optionally filtered by a search on name and email address and by activation
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param search   query string false "search"
+// @Param activated   query bool false "activated"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/admin/users [get]
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input listUsersRequest
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	if qs.Has("activated") {
+		activated := app.readBool(qs, "activated", false, v)
+		input.Activated = &activated
+	}
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Show a user
+// @Description returns a user together with their permissions
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Router /v1/admin/users/{id} [get]
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Activate or deactivate a user
+// @Description forces the activation state of a user. Deactivating a user also revokes all of their tokens and API keys.
+// @BasePath /
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id   path int true "id"
+// @Param request body setUserActivatedRequest true "Activation state"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 409 "Edit conflict"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/admin/users/{id}/activated [put]
+func (app *application) setUserActivatedHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input setUserActivatedRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user.Activated = input.Activated
+
+	action := data.AuditUserActivated
+	if !user.Activated {
+		action = data.AuditUserDeactivated
+	}
+
+	err = app.models.Users.SetActivated(user, app.auditEntry(r, action, user.ID, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Revoke the tokens of a user
+// @Description deletes every token and API key of a user, signing them out everywhere
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Param id   path int true "id"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/admin/users/{id}/tokens [delete]
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Users.RevokeAccess(user.ID, app.auditEntry(r, data.AuditTokensRevoked, user.ID, nil))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Grant permissions to a user
+// @Description grants one or more permissions to a user
+// @BasePath /
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id   path int true "id"
+// @Param request body grantPermissionsRequest true "Permissions to grant"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/admin/users/{id}/permissions [post]
+func (app *application) grantPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input grantPermissionsRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entry := app.auditEntry(r, data.AuditPermissionGranted, user.ID, map[string]interface{}{"permissions": input.Permissions})
+
+	err = app.models.Permissions.AddForUser(user.ID, input.Permissions, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownPermission):
+			v.AddError("permissions", "must only contain existing permissions")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writePermissions(w, r, user.ID)
+}
+
+// @Summary Revoke a permission from a user
+// @Description revokes a permission from a user
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Param id   path int true "id"
+// @Param code   path string true "permission code"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/admin/users/{id}/permissions/{code} [delete]
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	entry := app.auditEntry(r, data.AuditPermissionRevoked, user.ID, map[string]interface{}{"permissions": []string{code}})
+
+	err := app.models.Permissions.RemoveForUser(user.ID, []string{code}, entry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writePermissions(w, r, user.ID)
+}
+
+func (app *application) writePermissions(w http.ResponseWriter, r *http.Request, userID int64) {
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTargetUser loads the user named by the id parameter. When the user
+// cannot be loaded it writes the error response and returns false.
+func (app *application) readTargetUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// auditEntry describes an administrative action of the current user. The
+// model carrying out the action writes it in the same transaction.
+func (app *application) auditEntry(r *http.Request, action string, targetUserID int64, details map[string]interface{}) *data.AuditEntry {
+	return &data.AuditEntry{
+		ActorID:      app.contextGetUser(r).ID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Create an API key
+// @Description Creates a long-lived API key for the authenticated user. The key can only be given permissions the user has, and is only shown in this response. Keys can not be created by requests authenticated with an API key.
+// @BasePath /
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body createAPIKeyRequest true "Request body to create an API key"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account or API key"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/api-keys [post]
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input createAPIKeyRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		UserID:      user.ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		ExpiresAt:   input.ExpiresAt,
+	}
+	if key.Permissions == nil {
+		key.Permissions = []string{}
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return the API keys
+// @Description returns the API keys of the authenticated user, without their secrets
+// @BasePath /
+// @Tags users
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/api-keys [get]
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Revoke an API key
+// @Description revokes one of the API keys of the authenticated user
+// @BasePath /
+// @Tags users
+// @Produce json
+// @Param id   path int true "id"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account or API key"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/api-keys/{id} [delete]
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.APIKeys.Delete(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey    = contextKey("user")
+	apiKeyContextKey  = contextKey("apiKey")
+	sessionContextKey = contextKey("session")
+)
+
+// contextSetUser returns a copy of the request with the user added to its
+// context.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the user stored by the authenticate middleware. It
+// panics when there is none, since that can only happen through a wiring
+// mistake.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// contextSetAPIKey records that the request was authenticated with an API key.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or
+// nil when it was not made with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetSessionID records the session the request was authenticated with.
+func (app *application) contextSetSessionID(r *http.Request, id int64) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetSessionID returns the ID of the session the request was
+// authenticated with, or 0 when it was not made with a bearer token.
+func (app *application) contextGetSessionID(r *http.Request) int64 {
+	id, _ := r.Context().Value(sessionContextKey).(int64)
+	return id
+}
+
+// contextGetUserID returns the ID of the authenticated user, or nil for
+// anonymous requests, for recording who made a change.
+func (app *application) contextGetUserID(r *http.Request) *int64 {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return nil
+	}
+
+	return &user.ID
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Create a genre
+// @Description Adds a genre to the catalogue. The slug defaults to one derived from the name, and aliases are alternative spellings that are mapped onto the name.
+// @BasePath /
+// @Tags genres
+// @Accept json
+// @Produce json
+// @Param request body createGenreRequest true "Request body to create a genre"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/genres [post]
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input createGenreRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Slug:    data.Slugify(input.Name),
+		Name:    input.Name,
+		Aliases: input.Aliases,
+	}
+	if input.Slug != nil {
+		genre.Slug = *input.Slug
+	}
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "the name, slug or an alias is already used by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Show a genre
+// @Description returns a genre with the number of movies using it
+// @BasePath /
+// @Tags genres
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal server error"
+// @Param id   path int true "id"
+// @Router /v1/genres/{id} [get]
+func (app *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Update a genre
+// @Description Updates a genre. Renaming it also renames the genre on every movie that uses it.
+// @BasePath /
+// @Tags genres
+// @Accept json
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param request body updateGenreRequest true "Request body to update a genre"
+// @Router /v1/genres/{id} [patch]
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input updateGenreRequest
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	oldName := genre.Name
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+	if input.Slug != nil {
+		genre.Slug = *input.Slug
+	}
+	if input.Aliases != nil {
+		genre.Aliases = input.Aliases
+	}
+
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Update(genre, oldName, app.contextGetUserID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "the name, slug or an alias is already used by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete a genre
+// @Description Removes a genre from the catalogue, genres still used by a movie cannot be deleted
+// @BasePath /
+// @Tags genres
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 409 "Genre in use"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Router /v1/genres/{id} [delete]
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Genres.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGenreInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the genre is still used by at least one movie")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "genre successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return a list of genres
+// @Description returns the genre catalogue with the number of movies using each genre
+// @BasePath /
+// @Tags genres
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param name   query string false "name"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/genres [get]
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	var input listGenresRequest
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.SortSafelist = []string{"id", "name", "slug", "movie_count", "-id", "-name", "-slug", "-movie_count"}
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genres, metadata, err := app.models.Genres.GetAll(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"genres": genres, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nimaposhtiban/greenlight/internal/validator"
@@ -18,14 +20,19 @@ import (
 type envelope map[string]interface{}
 
 func (app *application) readIdParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// readInt64Param reads a positive integer from the named URL parameter.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	i, err := strconv.ParseInt(params.ByName(name), 10, 64)
 
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
-	return id, nil
+	return i, nil
 }
 
 func (app *application) writeJson(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -68,7 +75,7 @@ func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst int
 			}
 			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 		case errors.Is(err, io.EOF):
-			errors.New("body must not be empty")
+			return errors.New("body must not be empty")
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
@@ -126,6 +133,33 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readInclude reads a comma separated list of optional parts of a response
+// and returns the requested ones as a set.
+func (app *application) readInclude(qs url.Values, safelist []string, v *validator.Validator) map[string]bool {
+	include := make(map[string]bool)
+	for _, part := range app.readCSV(qs, "include", []string{}) {
+		if !validator.In(part, safelist...) {
+			v.AddError("include", "must only contain "+strings.Join(safelist, ", "))
+			continue
+		}
+		include[part] = true
+	}
+	return include
+}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
@@ -139,3 +173,55 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// periodic runs fn in the background every interval until stop is closed.
+func (app *application) periodic(stop <-chan struct{}, interval time.Duration, fn func()) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	})
+}
+
+// negotiateMediaType picks the first media type in the Accept header that is
+// also in offered. A missing header or a wildcard selects offered[0], and an
+// empty string means none of the offered types is acceptable.
+func negotiateMediaType(accept string, offered ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offered[0]
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == "*/*" {
+			return offered[0]
+		}
+		for _, o := range offered {
+			if mediaType == o || mediaType == strings.Split(o, "/")[0]+"/*" {
+				return o
+			}
+		}
+	}
+	return ""
+}
+
+// extendDeadlines replaces the server-wide read and write timeouts for
+// handlers that stream large request or response bodies.
+func (app *application) extendDeadlines(w http.ResponseWriter, d time.Duration) error {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(d)
+	err := rc.SetReadDeadline(deadline)
+	if err != nil {
+		return err
+	}
+	return rc.SetWriteDeadline(deadline)
+}
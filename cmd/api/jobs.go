@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/jobs"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// startJobs launches the bounded pool of job workers. They stop when stop is
+// closed, after finishing the job at hand, and are waited for like any other
+// background task.
+func (app *application) startJobs(stop <-chan struct{}) {
+	for i := 0; i < app.config.jobs.workers; i++ {
+		app.background(func() {
+			app.jobs.Work(stop)
+		})
+	}
+}
+
+// @Summary Return a list of background jobs
+// @Description returns a page of background jobs in the order they are due. Without a status, queued, running and failed jobs are listed.
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param status   query string false "status" Enums(queued, running, failed, done)
+// @Param kind   query string false "kind"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Router /v1/admin/jobs [get]
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var input listJobsRequest
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Kind = app.readString(qs, "kind", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.SortSafelist = []string{"run_at"}
+	input.Filters.Sort = "run_at"
+
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, jobs.StatusQueued, jobs.StatusRunning, jobs.StatusFailed, jobs.StatusDone), "status", "invalid status value")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	statuses := []string{jobs.StatusQueued, jobs.StatusRunning, jobs.StatusFailed}
+	if input.Status != "" {
+		statuses = []string{input.Status}
+	}
+
+	limit, offset := input.Filters.Bounds()
+	list, totalRecords, err := app.jobs.List(statuses, input.Kind, limit, offset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"jobs": list, "metadata": input.Filters.Metadata(totalRecords)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
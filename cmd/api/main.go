@@ -4,14 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
+	"net/mail"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/jobs"
 	"github.com/nimaposhtiban/greenlight/internal/jsonlog"
 	"github.com/nimaposhtiban/greenlight/internal/mailer"
+	"github.com/nimaposhtiban/greenlight/internal/oidc"
 )
 
 const version = "1.0.0"
@@ -30,21 +35,76 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	movies struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	auth struct {
+		maxFailures   int
+		ipMaxFailures int
+		backoff       time.Duration
+		lockout       time.Duration
+		window        time.Duration
+	}
+	tokens struct {
+		sweepInterval time.Duration
+	}
+	passwords struct {
+		minScore   int
+		breachList string
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	jobs struct {
+		workers      int
+		timeout      time.Duration
+		pollInterval time.Duration
+		retention    time.Duration
+	}
+	outbox struct {
+		workers      int
+		batchSize    int
+		maxAttempts  int
+		backoff      time.Duration
+		maxBackoff   time.Duration
+		pollInterval time.Duration
+	}
+	mailer struct {
+		backend string
+		dir     string
+	}
+	emails struct {
+		webhookSecret string
+	}
 	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
+		host        string
+		port        int
+		username    string
+		password    string
+		sender      string
+		poolSize    int
+		idleTimeout time.Duration
+	}
+	dkim struct {
+		key      string
+		selector string
+		domain   string
 	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config         config
+	logger         *jsonlog.Logger
+	models         data.Models
+	mailer         mailer.Mailer
+	wg             sync.WaitGroup
+	oidc           *oidc.Client
+	passwordPolicy data.PasswordPolicy
+	jobs           *jobs.Queue
 }
 
 func main() {
@@ -62,11 +122,53 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "ec221a69142f23", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "sa361a62de6284", "SMTP password")
+	flag.DurationVar(&cfg.movies.retention, "movies-retention", 30*24*time.Hour, "How long soft deleted movies are kept before being purged (0 disables purging)")
+	flag.DurationVar(&cfg.movies.purgeInterval, "movies-purge-interval", time.Hour, "How often soft deleted movies are purged")
+
+	flag.IntVar(&cfg.auth.maxFailures, "auth-max-failures", 10, "Failed logins after which an account is locked")
+	flag.IntVar(&cfg.auth.ipMaxFailures, "auth-ip-max-failures", 100, "Failed logins after which a client IP is locked")
+	flag.DurationVar(&cfg.auth.backoff, "auth-backoff", time.Second, "Delay after the first failed login of an account, doubled by every further failure")
+	flag.DurationVar(&cfg.auth.lockout, "auth-lockout", 15*time.Minute, "How long accounts and client IPs stay locked")
+	flag.DurationVar(&cfg.auth.window, "auth-failure-window", time.Hour, "How long failed logins are remembered")
+
+	flag.DurationVar(&cfg.tokens.sweepInterval, "tokens-sweep-interval", time.Hour, "How often expired tokens are deleted (0 disables the sweeper)")
+
+	flag.IntVar(&cfg.passwords.minScore, "password-min-score", 3, "Minimum estimated strength of new passwords, from 0 (anything) to 4")
+	flag.StringVar(&cfg.passwords.breachList, "password-breach-list", "", "File of breached SHA-1 password hashes that new passwords are checked against")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL (empty disables OIDC sign-in)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:8000/v1/auth/oidc/callback", "OpenID Connect redirect URL")
+
+	flag.IntVar(&cfg.jobs.workers, "jobs-workers", 4, "Number of workers running background jobs")
+	flag.DurationVar(&cfg.jobs.timeout, "jobs-timeout", 5*time.Minute, "How long a background job may run")
+	flag.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "How often idle workers look for due jobs")
+	flag.DurationVar(&cfg.jobs.retention, "jobs-retention", 7*24*time.Hour, "How long finished jobs are kept (0 keeps them forever)")
+
+	flag.IntVar(&cfg.outbox.workers, "outbox-workers", 2, "Number of workers delivering queued emails")
+	flag.IntVar(&cfg.outbox.batchSize, "outbox-batch-size", 10, "Emails claimed by a worker at a time")
+	flag.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 8, "Delivery attempts after which an email is moved to the dead letter state")
+	flag.DurationVar(&cfg.outbox.backoff, "outbox-backoff", 30*time.Second, "Delay after the first failed delivery, doubled by every further failure")
+	flag.DurationVar(&cfg.outbox.maxBackoff, "outbox-max-backoff", time.Hour, "Longest delay between delivery attempts")
+	flag.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "How often idle workers look for queued emails")
+
+	flag.StringVar(&cfg.mailer.backend, "mailer", "dir", "How emails are delivered (smtp|dir|memory)")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "tmp/mail", "Directory the dir mailer writes .eml files to")
+
+	flag.StringVar(&cfg.emails.webhookSecret, "email-webhook-secret", "", "Secret that bounce and complaint notifications are signed with (empty disables the webhook)")
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <poshtibannima@gmail.com>", "SMTP sender")
+	flag.IntVar(&cfg.smtp.poolSize, "smtp-pool-size", 4, "Maximum open SMTP connections")
+	flag.DurationVar(&cfg.smtp.idleTimeout, "smtp-idle-timeout", 30*time.Second, "How long an unused SMTP connection is kept open")
+
+	flag.StringVar(&cfg.dkim.key, "dkim-key", "", "PEM file with the RSA or Ed25519 key to DKIM sign emails with (disabled when empty)")
+	flag.StringVar(&cfg.dkim.selector, "dkim-selector", "default", "DKIM selector")
+	flag.StringVar(&cfg.dkim.domain, "dkim-domain", "", "DKIM signing domain (default the domain of -smtp-sender)")
 
 	flag.Parse()
 
@@ -79,18 +181,92 @@ func main() {
 
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
+
+	mail, err := newMailer(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	passwordPolicy := data.PasswordPolicy{
+		data.PersonalInfoChecker{},
+		data.StrengthChecker{MinScore: cfg.passwords.minScore},
+	}
+	if cfg.passwords.breachList != "" {
+		breached, err := data.LoadBreachedPasswords(cfg.passwords.breachList)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		passwordPolicy = append(passwordPolicy, breached)
+	}
+
 	app := &application{
 		cfg,
 		logger,
 		data.NewModels(db),
-		mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		mail,
 		sync.WaitGroup{},
+		nil,
+		passwordPolicy,
+		jobs.New(db, logger, cfg.jobs.timeout, cfg.jobs.pollInterval),
+	}
+	app.registerMaintenanceJobs()
+
+	if cfg.oidc.issuer != "" {
+		app.oidc = oidc.New(oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		}, nil)
 	}
 
 	err = app.server()
 	logger.PrintFatal(err, nil)
 }
 
+// newMailer returns the mailer selected with the -mailer flag.
+func newMailer(cfg config) (mailer.Mailer, error) {
+	templates, err := mailer.LoadTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.mailer.backend {
+	case "smtp":
+		smtpConfig := mailer.SMTPConfig{
+			Host:        cfg.smtp.host,
+			Port:        cfg.smtp.port,
+			Username:    cfg.smtp.username,
+			Password:    cfg.smtp.password,
+			Sender:      cfg.smtp.sender,
+			PoolSize:    cfg.smtp.poolSize,
+			IdleTimeout: cfg.smtp.idleTimeout,
+		}
+		if cfg.dkim.key != "" {
+			domain := cfg.dkim.domain
+			if domain == "" {
+				addr, err := mail.ParseAddress(cfg.smtp.sender)
+				if err != nil {
+					return nil, fmt.Errorf("dkim: invalid sender: %w", err)
+				}
+				_, domain, _ = strings.Cut(addr.Address, "@")
+			}
+
+			smtpConfig.DKIM, err = mailer.LoadDKIMSigner(cfg.dkim.key, domain, cfg.dkim.selector)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return mailer.NewSMTP(templates, smtpConfig), nil
+	case "dir":
+		return mailer.NewDir(templates, cfg.mailer.dir, cfg.smtp.sender)
+	case "memory":
+		return mailer.NewMemory(templates, cfg.smtp.sender), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.mailer.backend)
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/jobs"
+)
+
+// Kinds of the housekeeping jobs.
+const (
+	jobPurgeDeletedMovies         = "movies.purge_deleted"
+	jobDeleteExpiredLoginFailures = "logins.delete_expired_failures"
+	jobDeleteExpiredTokens        = "tokens.delete_expired"
+	jobDeleteExpiredOIDCStates    = "oidc.delete_expired_states"
+	jobDeleteFinishedJobs         = "jobs.delete_finished"
+)
+
+// maintenanceRetries retries housekeeping a few times before giving up until
+// it is scheduled again.
+var maintenanceRetries = jobs.RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     time.Minute,
+	MaxBackoff:  10 * time.Minute,
+}
+
+// registerMaintenanceJobs registers the handlers of the housekeeping jobs.
+func (app *application) registerMaintenanceJobs() {
+	app.jobs.Register(jobPurgeDeletedMovies, maintenanceRetries, app.purgeDeletedMovies)
+	app.jobs.Register(jobDeleteExpiredLoginFailures, maintenanceRetries, app.deleteExpiredLoginFailures)
+	app.jobs.Register(jobDeleteExpiredTokens, maintenanceRetries, app.deleteExpiredTokens)
+	app.jobs.Register(jobDeleteExpiredOIDCStates, maintenanceRetries, app.deleteExpiredOIDCStates)
+	app.jobs.Register(jobDeleteFinishedJobs, maintenanceRetries, app.deleteFinishedJobs)
+}
+
+// startMaintenance schedules the periodic housekeeping jobs. Scheduling stops
+// when stop is closed.
+func (app *application) startMaintenance(stop <-chan struct{}) {
+	if app.config.movies.retention > 0 {
+		app.schedule(stop, app.config.movies.purgeInterval, jobPurgeDeletedMovies)
+	}
+	if app.config.auth.window > 0 {
+		app.schedule(stop, app.config.auth.window, jobDeleteExpiredLoginFailures)
+	}
+	if app.config.tokens.sweepInterval > 0 {
+		app.schedule(stop, app.config.tokens.sweepInterval, jobDeleteExpiredTokens)
+	}
+	if app.oidc != nil {
+		app.schedule(stop, time.Hour, jobDeleteExpiredOIDCStates)
+	}
+	if app.config.jobs.retention > 0 {
+		app.schedule(stop, time.Hour, jobDeleteFinishedJobs)
+	}
+}
+
+// schedule queues a job of the given kind every interval. The kind doubles
+// as unique key, so when several instances of the API run, a job that is
+// still queued or running is not queued again.
+func (app *application) schedule(stop <-chan struct{}, interval time.Duration, kind string) {
+	app.periodic(stop, interval, func() {
+		_, err := app.jobs.Enqueue(kind, nil, jobs.Options{UniqueKey: kind})
+		if err != nil && !errors.Is(err, jobs.ErrDuplicate) {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// logDeleted logs how many rows a housekeeping job removed.
+func (app *application) logDeleted(message string, n int64) {
+	if n > 0 {
+		app.logger.PrintInfo(message, map[string]string{
+			"count": strconv.FormatInt(n, 10),
+		})
+	}
+}
+
+// purgeDeletedMovies permanently removes movies that have been soft deleted
+// for longer than the configured retention period.
+func (app *application) purgeDeletedMovies(ctx context.Context, job *jobs.Job) error {
+	n, err := app.models.Movies.Purge(app.config.movies.retention)
+	if err != nil {
+		return err
+	}
+	app.logDeleted("purged deleted movies", n)
+	return nil
+}
+
+// deleteExpiredLoginFailures removes failed login counters that no longer
+// count towards a lockout.
+func (app *application) deleteExpiredLoginFailures(ctx context.Context, job *jobs.Job) error {
+	n, err := app.models.LoginFailures.DeleteExpired(app.config.auth.window)
+	if err != nil {
+		return err
+	}
+	app.logDeleted("deleted expired login failures", n)
+	return nil
+}
+
+// deleteExpiredTokens removes activation and authentication tokens that can
+// no longer be used.
+func (app *application) deleteExpiredTokens(ctx context.Context, job *jobs.Job) error {
+	n, err := app.models.Tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	app.logDeleted("deleted expired tokens", n)
+	return nil
+}
+
+// deleteExpiredOIDCStates removes identity provider sign-ins that were never
+// completed.
+func (app *application) deleteExpiredOIDCStates(ctx context.Context, job *jobs.Job) error {
+	n, err := app.models.Identities.DeleteExpiredStates()
+	if err != nil {
+		return err
+	}
+	app.logDeleted("deleted expired oidc states", n)
+	return nil
+}
+
+// deleteFinishedJobs removes jobs that finished longer ago than the
+// configured retention period.
+func (app *application) deleteFinishedJobs(ctx context.Context, job *jobs.Job) error {
+	n, err := app.jobs.DeleteFinished(app.config.jobs.retention)
+	if err != nil {
+		return err
+	}
+	app.logDeleted("deleted finished jobs", n)
+	return nil
+}
@@ -8,6 +8,10 @@ import (
 	"net/http"
 )
 
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+var movieIncludeSafelist = []string{"credits"}
+
 // createMovieRequest represents the request body to create a movie.
 
 // createMovieHandler handles the HTTP POST request to create a new movie.
@@ -30,20 +34,26 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	movie := &data.Movie{
 		Title:   input.Title,
 		Year:    input.Year,
 		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Genres:  catalog.Normalize(input.Genres),
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, catalog); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUserID(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -67,10 +77,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 // @Produce json
 // @Success 200  {object}  getMovieResult
 // @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
 // @Failure 404 "Not found"
 // @Failure 500 "Internal server error"
 // @Router /v1/movies/{id} [get]
 // @Param id   path int true "id"
+// @Param include_deleted   query bool false "include soft deleted movies (requires movies:admin)"
+// @Param include   query string false "credits"
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 
 	id, err := app.readIdParam(r)
@@ -79,8 +93,25 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
-	// this is only for testing purposes, remove this ASAP
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+	qs := r.URL.Query()
+	includeDeleted := app.readBool(qs, "include_deleted", false, v)
+	include := app.readInclude(qs, movieIncludeSafelist, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if includeDeleted && !app.allowDeletedMovies(w, r) {
+		return
+	}
+
+	var movie *data.Movie
+	if includeDeleted {
+		movie, err = app.models.Movies.GetIncludingDeleted(id)
+	} else {
+		movie, err = app.models.Movies.Get(id)
+	}
 
 	if err != nil {
 		switch {
@@ -92,6 +123,14 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if include["credits"] {
+		movie.Credits, err = app.models.People.GetCredits(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	err = app.writeJson(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -154,13 +193,20 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		movie.Genres = input.Genres
 	}
 
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = catalog.Normalize(movie.Genres)
+
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, catalog); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUserID(r))
 
 	if err != nil {
 		switch {
@@ -182,7 +228,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 // Delete handles the HTTP Delete request to delete a movie.
 // @Summary Delete a movie
-// @Description Delete a  movie with the provided id
+// @Description Soft delete a movie with the provided id, it can be restored until it is purged
 // @BasePath /
 // @Tags movies
 // @Produce json
@@ -199,7 +245,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, app.contextGetUserID(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -218,12 +264,50 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 }
 
+// @Summary Restore a deleted movie
+// @Description Restores a soft deleted movie that has not been purged yet. Requires the movies:admin permission.
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200  {object}  getMovieResult
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Router /v1/movies/{id}/restore [post]
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(id, app.contextGetUserID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // @Summary Return a list of movies
 // @Description returns a list of movies based on provided query string
 // @BasePath /
 // @Tags movies
 // @Produce json
 // @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
 // @Failure 422 "Invalid request"
 // @Failure 500 "Internal Server Error"
 // @Param title   query string false "title"
@@ -231,6 +315,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 // @Param page   query int false "page"
 // @Param page_size   query int false "page_size"
 // @Param sort   query string false "sort"
+// @Param include_deleted   query bool false "include soft deleted movies (requires movies:admin)"
+// @Param person_id   query int false "only movies crediting this person"
+// @Param include   query string false "credits"
 // @Router /v1/movies [get]
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input listMoviesRequest
@@ -241,26 +328,89 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.IncludeDeleted = app.readBool(qs, "include_deleted", false, v)
+	input.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+	input.Include = app.readInclude(qs, movieIncludeSafelist, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = movieSortSafelist
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
+	v.Check(input.PersonID >= 0, "person_id", "must be a positive integer")
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	if input.IncludeDeleted && !app.allowDeletedMovies(w, r) {
+		return
+	}
+
+	catalog, err := app.models.Genres.Catalog()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, catalog.Normalize(input.Genres), input.PersonID, input.IncludeDeleted, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.Include["credits"] && len(movies) > 0 {
+		ids := make([]int64, len(movies))
+		for i, movie := range movies {
+			ids[i] = movie.ID
+		}
+
+		credits, err := app.models.People.GetCreditsForMovies(ids)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, movie := range movies {
+			movie.Credits = credits[movie.ID]
+			if movie.Credits == nil {
+				movie.Credits = []*data.Credit{}
+			}
+		}
+	}
+
 	err = app.writeJson(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// allowDeletedMovies reports whether the request may see soft deleted
+// movies, which takes the movies:admin permission. When it may not, it writes
+// the error response.
+func (app *application) allowDeletedMovies(w http.ResponseWriter, r *http.Request) bool {
+	user := app.contextGetUser(r)
+
+	switch {
+	case user.IsAnonymous():
+		app.authenticationRequiredResponse(w, r)
+		return false
+	case !user.Activated:
+		app.inactiveAccountResponse(w, r)
+		return false
+	}
+
+	permitted, err := app.hasPermission(r, data.PermissionMoviesAdmin)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
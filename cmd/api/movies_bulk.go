@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+const maxBulkItems = 1000
+
+const (
+	bulkModeAtomic     = "atomic"
+	bulkModeBestEffort = "best_effort"
+)
+
+type bulkMovieResult struct {
+	Index   int               `json:"index"`
+	Status  string            `json:"status"`
+	ID      int64             `json:"id,omitempty"`
+	Version int32             `json:"version,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// @Summary Create movies in bulk
+// @Description Validates and creates every movie in the array. In atomic mode (default) nothing is written unless every item succeeds, in best_effort mode each item is created independently.
+// @BasePath /
+// @Tags movies
+// @Accept json
+// @Produce json
+// @Param mode query string false "atomic or best_effort"
+// @Param request body []createMovieRequest true "Movies to create"
+// @Success 200 "Per-item results (best_effort)"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 422 "Per-item results, nothing was written"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/bulk [post]
+func (app *application) bulkCreateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	atomic := app.readBulkMode(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var input []createMovieRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if validateBulkSize(v, len(input)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]bulkMovieResult, len(input))
+	movies := []*data.Movie{}
+	pending := []int{}
+
+	for i, item := range input {
+		results[i].Index = i
+
+		movie := &data.Movie{
+			Title:   item.Title,
+			Year:    item.Year,
+			Runtime: item.Runtime,
+			Genres:  catalog.Normalize(item.Genres),
+		}
+
+		iv := validator.New()
+		if data.ValidateMovie(iv, movie, catalog); !iv.Valid() {
+			results[i].Status = "failed"
+			results[i].Errors = iv.Errors
+			continue
+		}
+
+		movies = append(movies, movie)
+		pending = append(pending, i)
+	}
+
+	if atomic && len(pending) != len(input) {
+		app.writeBulkResults(w, r, results, pending, nil, data.ErrBulkAborted, "", http.StatusUnprocessableEntity, nil)
+		return
+	}
+
+	errs, err := app.models.Movies.InsertMany(movies, atomic, app.contextGetUserID(r))
+	app.writeBulkResults(w, r, results, pending, errs, err, "created", http.StatusCreated, func(j int) *data.Movie {
+		return movies[j]
+	})
+}
+
+// @Summary Update movies in bulk
+// @Description Applies a partial update to every movie in the array. When version is given it must match the stored version. In atomic mode (default) nothing is written unless every item succeeds.
+// @BasePath /
+// @Tags movies
+// @Accept json
+// @Produce json
+// @Param mode query string false "atomic or best_effort"
+// @Param request body []bulkUpdateMovieRequest true "Movies to update"
+// @Success 200 "Per-item results"
+// @Failure 400 "Bad Request"
+// @Failure 422 "Per-item results, nothing was written"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/bulk [patch]
+func (app *application) bulkUpdateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	atomic := app.readBulkMode(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var input []bulkUpdateMovieRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if validateBulkSize(v, len(input)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]bulkMovieResult, len(input))
+	movies := []*data.Movie{}
+	pending := []int{}
+
+	for i, item := range input {
+		results[i].Index = i
+		results[i].ID = item.ID
+
+		movie, err := app.models.Movies.Get(item.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				results[i].Status = "failed"
+				results[i].Errors = app.bulkItemErrors(r, err)
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if item.Version != nil && *item.Version != movie.Version {
+			results[i].Status = "failed"
+			results[i].Errors = app.bulkItemErrors(r, data.ErrEditConflict)
+			continue
+		}
+
+		if item.Title != nil {
+			movie.Title = *item.Title
+		}
+		if item.Year != nil {
+			movie.Year = *item.Year
+		}
+		if item.Runtime != nil {
+			movie.Runtime = *item.Runtime
+		}
+		if item.Genres != nil {
+			movie.Genres = catalog.Normalize(item.Genres)
+		}
+
+		iv := validator.New()
+		if data.ValidateMovie(iv, movie, catalog); !iv.Valid() {
+			results[i].Status = "failed"
+			results[i].Errors = iv.Errors
+			continue
+		}
+
+		movies = append(movies, movie)
+		pending = append(pending, i)
+	}
+
+	if atomic && len(pending) != len(input) {
+		app.writeBulkResults(w, r, results, pending, nil, data.ErrBulkAborted, "", http.StatusUnprocessableEntity, nil)
+		return
+	}
+
+	errs, err := app.models.Movies.UpdateMany(movies, atomic, app.contextGetUserID(r))
+	app.writeBulkResults(w, r, results, pending, errs, err, "updated", http.StatusOK, func(j int) *data.Movie {
+		return movies[j]
+	})
+}
+
+// @Summary Delete movies in bulk
+// @Description Deletes every movie whose id is in the array. In atomic mode (default) nothing is deleted unless every id exists.
+// @BasePath /
+// @Tags movies
+// @Accept json
+// @Produce json
+// @Param mode query string false "atomic or best_effort"
+// @Param request body []int true "Movie ids to delete"
+// @Success 200 "Per-item results"
+// @Failure 400 "Bad Request"
+// @Failure 422 "Per-item results, nothing was deleted"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/bulk [delete]
+func (app *application) bulkDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	atomic := app.readBulkMode(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var ids []int64
+	err := app.readJson(w, r, &ids)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if validateBulkSize(v, len(ids)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]bulkMovieResult, len(ids))
+	pending := make([]int, len(ids))
+	for i, id := range ids {
+		results[i].Index = i
+		results[i].ID = id
+		pending[i] = i
+	}
+
+	errs, err := app.models.Movies.DeleteMany(ids, atomic, app.contextGetUserID(r))
+	app.writeBulkResults(w, r, results, pending, errs, err, "deleted", http.StatusOK, nil)
+}
+
+// readBulkMode reads the "mode" query parameter and reports whether the batch
+// should be applied atomically.
+func (app *application) readBulkMode(qs url.Values, v *validator.Validator) bool {
+	mode := app.readString(qs, "mode", bulkModeAtomic)
+	v.Check(validator.In(mode, bulkModeAtomic, bulkModeBestEffort), "mode", "must be atomic or best_effort")
+	return mode == bulkModeAtomic
+}
+
+func validateBulkSize(v *validator.Validator, n int) {
+	v.Check(n > 0, "body", "must contain at least one item")
+	v.Check(n <= maxBulkItems, "body", "must not contain more than 1000 items")
+}
+
+// writeBulkResults merges the outcome of a bulk model call into results and
+// writes the response. pending maps each entry of errs back to its position in
+// the request body, and movie (when non-nil) returns the movie for an entry of
+// errs so its id and version can be reported.
+func (app *application) writeBulkResults(w http.ResponseWriter, r *http.Request, results []bulkMovieResult, pending []int, errs []error, err error, done string, status int, movie func(int) *data.Movie) {
+	aborted := errors.Is(err, data.ErrBulkAborted)
+	if err != nil && !aborted {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	failed := len(pending) != len(results)
+
+	for j, i := range pending {
+		switch {
+		case errs != nil && errs[j] != nil:
+			failed = true
+			results[i].Status = "failed"
+			results[i].Errors = app.bulkItemErrors(r, errs[j])
+		case aborted:
+			results[i].Status = "skipped"
+		default:
+			results[i].Status = done
+			if movie != nil {
+				results[i].ID = movie(j).ID
+				results[i].Version = movie(j).Version
+			}
+		}
+	}
+
+	switch {
+	case aborted:
+		status = http.StatusUnprocessableEntity
+	case failed:
+		status = http.StatusOK
+	}
+
+	err = app.writeJson(w, status, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) bulkItemErrors(r *http.Request, err error) map[string]string {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return map[string]string{"id": "the requested resource could not be found"}
+	case errors.Is(err, data.ErrEditConflict):
+		return map[string]string{"version": "unable to update the record due to an edit conflict, please try again"}
+	default:
+		app.logError(r, err)
+		return map[string]string{"error": "the server has encountered a problem"}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+const (
+	csvMediaType       = "text/csv"
+	ndjsonMediaType    = "application/x-ndjson"
+	ndjsonAltMediaType = "application/ndjson"
+
+	maxImportBytes  = 100 << 20
+	maxImportErrors = 1000
+	transferTimeout = 5 * time.Minute
+)
+
+var errImportRejected = errors.New("import rejected")
+
+var movieCSVHeader = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+// movieRecord is the flat representation of a movie used by the CSV and
+// NDJSON formats. Runtime is a plain number of minutes so that an export can
+// be imported again unchanged.
+type movieRecord struct {
+	ID      int64    `json:"id,omitempty"`
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime int32    `json:"runtime"`
+	Genres  []string `json:"genres"`
+	Version int32    `json:"version,omitempty"`
+}
+
+type importLineError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+type importReport struct {
+	Inserted int               `json:"inserted"`
+	Failed   int               `json:"failed"`
+	Errors   []importLineError `json:"errors"`
+}
+
+func (rep *importReport) fail(line int, errs map[string]string) {
+	rep.Failed++
+	if len(rep.Errors) < maxImportErrors {
+		rep.Errors = append(rep.Errors, importLineError{Line: line, Errors: errs})
+	}
+}
+
+// @Summary Export movies
+// @Description Streams every movie matching the filters as CSV or NDJSON, chosen by the Accept header
+// @BasePath /
+// @Tags movies
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 406 "Not Acceptable"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param title   query string false "title"
+// @Param genres   query string false "genres"
+// @Param sort   query string false "sort"
+// @Param include_deleted   query bool false "include soft deleted movies (requires movies:admin)"
+// @Param person_id   query int false "only movies crediting this person"
+// @Router /v1/movies/export [get]
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType := negotiateMediaType(r.Header.Get("Accept"), csvMediaType, ndjsonMediaType, ndjsonAltMediaType)
+	if mediaType == "" {
+		app.notAcceptableResponse(w, r, csvMediaType, ndjsonMediaType)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", []string{})
+	includeDeleted := app.readBool(qs, "include_deleted", false, v)
+	personID := int64(app.readInt(qs, "person_id", 0, v))
+	filters := data.Filters{
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: movieSortSafelist,
+	}
+
+	v.Check(personID >= 0, "person_id", "must be a positive integer")
+
+	if v.Check(validator.In(filters.Sort, filters.SortSafelist...), "sort", "invalid sort value"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if includeDeleted && !app.allowDeletedMovies(w, r) {
+		return
+	}
+
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	genres = catalog.Normalize(genres)
+
+	err = app.extendDeadlines(w, transferTimeout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+
+	// The status line is only sent once the first row is ready, so that a
+	// failing query can still be reported as a JSON error.
+	started := false
+	start := func() error {
+		started = true
+		extension := "ndjson"
+		if mediaType == csvMediaType {
+			extension = "csv"
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, extension))
+		w.WriteHeader(http.StatusOK)
+		if mediaType == csvMediaType {
+			return cw.Write(movieCSVHeader)
+		}
+		return nil
+	}
+
+	err = app.models.Movies.Export(title, genres, personID, includeDeleted, filters, func(movie *data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+		if mediaType == csvMediaType {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)),
+				strings.Join(movie.Genres, ","),
+				strconv.Itoa(int(movie.Version)),
+			})
+		}
+		return enc.Encode(movieRecord{
+			ID:      movie.ID,
+			Title:   movie.Title,
+			Year:    movie.Year,
+			Runtime: int32(movie.Runtime),
+			Genres:  movie.Genres,
+			Version: movie.Version,
+		})
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// The response is already under way, so all we can do is log the
+		// failure and leave the client with a truncated body.
+		app.logError(r, err)
+		return
+	}
+
+	cw.Flush()
+	err = cw.Error()
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// @Summary Import movies
+// @Description Streams a CSV or NDJSON upload into the database. CSV needs a header row with title, year, runtime and genres columns; genres are comma separated and runtime is in minutes. In atomic mode (default) nothing is written if any line fails. In best_effort mode lines that fail validation or are rejected by the database are reported and skipped.
+// @BasePath /
+// @Tags movies
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param mode query string false "atomic or best_effort"
+// @Success 200 "Imported with per-line errors (best_effort)"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 415 "Unsupported Media Type"
+// @Failure 422 "Per-line errors, nothing was written"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/import [post]
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	atomic := app.readBulkMode(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !validator.In(mediaType, csvMediaType, ndjsonMediaType, ndjsonAltMediaType) {
+		app.unsupportedMediaTypeResponse(w, r, csvMediaType, ndjsonMediaType)
+		return
+	}
+
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.extendDeadlines(w, transferTimeout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	decode := decodeMovieNDJSON
+	if mediaType == csvMediaType {
+		decode = decodeMovieCSV
+	}
+
+	report := importReport{Errors: []importLineError{}}
+	var decodeErr, copyErr error
+
+	count, err := app.models.Movies.Import(func(add func(*data.Movie) error) error {
+		decodeErr = decode(r.Body, func(line int, record movieRecord, errs map[string]string) error {
+			if len(errs) > 0 {
+				report.fail(line, errs)
+				return nil
+			}
+
+			movie := &data.Movie{
+				Title:   record.Title,
+				Year:    record.Year,
+				Runtime: data.Runtime(record.Runtime),
+				Genres:  catalog.Normalize(record.Genres),
+			}
+
+			iv := validator.New()
+			if data.ValidateMovie(iv, movie, catalog); !iv.Valid() {
+				report.fail(line, iv.Errors)
+				return nil
+			}
+
+			// Keep validating so every bad line is reported, but stop
+			// copying once an atomic import is bound to be rolled back.
+			if atomic && report.Failed > 0 {
+				return nil
+			}
+			copyErr = add(movie)
+			var rowErr *data.ImportRowError
+			if errors.As(copyErr, &rowErr) {
+				app.logError(r, rowErr.Err)
+				report.fail(line, map[string]string{"line": "could not be saved"})
+				copyErr = nil
+			}
+			return copyErr
+		})
+		if decodeErr != nil {
+			return decodeErr
+		}
+		if atomic && report.Failed > 0 {
+			return errImportRejected
+		}
+		return nil
+	}, atomic, app.contextGetUserID(r))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.Is(err, errImportRejected):
+			err = app.writeJson(w, http.StatusUnprocessableEntity, envelope{"import": report}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		case copyErr == nil && decodeErr != nil:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	report.Inserted = count
+
+	status := http.StatusCreated
+	if report.Failed > 0 {
+		status = http.StatusOK
+	}
+
+	err = app.writeJson(w, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// decodeMovieCSV reads CSV rows from body and passes each one to fn together
+// with its line number and any per-field parse errors. Only errors that make
+// the rest of the input unreadable are returned.
+func decodeMovieCSV(body io.Reader, fn func(int, movieRecord, map[string]string) error) error {
+	cr := csv.NewReader(body)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("body must not be empty")
+		}
+		return err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("header row is missing the %q column", name)
+		}
+	}
+
+	for {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			err = fn(parseError.StartLine, movieRecord{}, map[string]string{"line": parseError.Err.Error()})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := cr.FieldPos(0)
+		v := validator.New()
+
+		record := movieRecord{
+			Title:   fields[columns["title"]],
+			Year:    parseInt32(v, "year", fields[columns["year"]]),
+			Runtime: parseInt32(v, "runtime", fields[columns["runtime"]]),
+			Genres:  []string{},
+		}
+		for _, genre := range strings.Split(fields[columns["genres"]], ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				record.Genres = append(record.Genres, genre)
+			}
+		}
+
+		err = fn(line, record, v.Errors)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeMovieNDJSON reads one JSON object per line from body and passes each
+// one to fn in the same way as decodeMovieCSV. Blank lines are skipped.
+func decodeMovieNDJSON(body io.Reader, fn func(int, movieRecord, map[string]string) error) error {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+
+	line := 0
+	for sc.Scan() {
+		line++
+
+		raw := bytes.TrimSpace(sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var record movieRecord
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+
+		var errs map[string]string
+		err := dec.Decode(&record)
+		if err != nil {
+			errs = map[string]string{"line": strings.TrimPrefix(err.Error(), "json: ")}
+		}
+
+		err = fn(line, record, errs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
+
+func parseInt32(v *validator.Validator, key, s string) int32 {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return 0
+	}
+	return int32(i)
+}
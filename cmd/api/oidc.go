@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/oidc"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Sign in with the identity provider
+// @Description Redirects to the OpenID Connect identity provider to sign in with the authorization code flow and PKCE. Only available when an issuer is configured.
+// @BasePath /
+// @Tags tokens
+// @Success 302 "Redirect to the identity provider"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/auth/oidc/login [get]
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.RandomString(32)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nonce, err := oidc.RandomString(32)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	verifier, challenge, err := oidc.GeneratePKCE()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Identities.InsertState(&data.OIDCState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Expiry:       time.Now().Add(10 * time.Minute),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	redirectURL, err := app.oidc.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// @Summary Complete sign in with the identity provider
+// @Description Exchanges the authorization code for an ID token, links the identity to the Greenlight account with the same verified email address and returns an authentication token. Users enrolled in two-factor authentication get a short-lived two_factor_token instead, to be exchanged with their code at /v1/tokens/two-factor.
+// @BasePath /
+// @Tags tokens
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State returned by the identity provider"
+// @Success 201 "Created"
+// @Success 202 "Second factor required"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Invalid credentials"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/auth/oidc/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	if qs.Get("error") != "" {
+		app.errorResponse(w, r, http.StatusUnauthorized, "the identity provider refused the sign in: "+qs.Get("error"))
+		return
+	}
+
+	state, err := app.models.Identities.ConsumeState(qs.Get("state"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired state parameter"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	claims, err := app.oidc.Exchange(ctx, qs.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Identities.GetUser(claims.Issuer, claims.Subject)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		user, err = app.linkIdentity(claims)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.errorResponse(w, r, http.StatusUnauthorized, "no account with a verified email address matches this identity")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The identity provider only stands in for the password. Users enrolled
+	// in two-factor authentication exchange this token and their code at
+	// /v1/tokens/two-factor.
+	if enrolment != nil && enrolment.Confirmed {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJson(w, http.StatusAccepted, envelope{"two_factor_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.newSession(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// linkIdentity links a new identity to the account with the same email
+// address, provided the identity provider has verified it. As the provider
+// vouches for the address, the account is activated too, dropping any
+// password it was registered with. It returns ErrRecordNotFound when no
+// account can be linked.
+func (app *application) linkIdentity(claims *oidc.Claims) (*data.User, error) {
+	if !claims.EmailVerified || claims.Email == "" {
+		return nil, data.ErrRecordNotFound
+	}
+
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Identities.Link(user, claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// @Summary Complete a sign in with the second factor
+// @Description Exchanges the two_factor_token of an identity provider sign in and a totp_code or recovery_code for an authentication token. Failures count towards the lockout of the account like failed password logins.
+// @BasePath /
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param request body createTwoFactorTokenRequest true "Two-factor token and code"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Invalid credentials"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/tokens/two-factor [post]
+func (app *application) createTwoFactorTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input createTwoFactorTokenRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.Token)
+	v.Check(input.TOTPCode != "" || input.RecoveryCode != "", "totp_code", "a totp_code or recovery_code must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accountKey := data.AccountLoginKey(user.Email)
+	ipKey := data.IPLoginKey(ip)
+
+	locked, err := app.models.LoginFailures.Locked(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if locked {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The enrolment may have been removed since the sign in began, in which
+	// case there is no second factor left to check.
+	if enrolment != nil && enrolment.Confirmed {
+		ok, err := app.checkSecondFactor(enrolment, input.TOTPCode, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			app.recordLoginFailure(w, r, user, accountKey, ipKey)
+			return
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginFailures.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.newSession(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
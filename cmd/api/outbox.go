@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/jobs"
+	"github.com/nimaposhtiban/greenlight/internal/mailer"
+)
+
+// outboxLease is how long a claimed email is left alone before another
+// worker may retry it. It only matters when a worker dies mid-send.
+const outboxLease = 5 * time.Minute
+
+// startOutbox launches the workers that deliver queued emails. Each worker
+// keeps claiming batches while there is a backlog and otherwise polls. They
+// stop when stop is closed and are waited for like any other background task.
+func (app *application) startOutbox(stop <-chan struct{}) {
+	for i := 0; i < app.config.outbox.workers; i++ {
+		app.background(func() {
+			for {
+				n := app.deliverOutbox()
+
+				if n < app.config.outbox.batchSize {
+					select {
+					case <-stop:
+						return
+					case <-time.After(app.config.outbox.pollInterval):
+					}
+					continue
+				}
+
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		})
+	}
+}
+
+// deliverOutbox sends one batch of due emails and returns how many were
+// claimed.
+func (app *application) deliverOutbox() int {
+	emails, err := app.models.Outbox.Claim(app.config.outbox.batchSize, outboxLease)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return 0
+	}
+
+	for _, email := range emails {
+		suppressed, err := app.models.Suppressions.Suppressed(email.Recipient)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		if suppressed {
+			app.logger.PrintInfo("skipped email to suppressed address", map[string]string{
+				"email_id": strconv.FormatInt(email.ID, 10),
+				"template": email.Template,
+			})
+			err = app.models.Outbox.MarkSuppressed(email.ID)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			continue
+		}
+
+		// Every attempt carries the same Message-ID, so that deliveries can be
+		// matched with the outbox row in logs.
+		msg := mailer.NewEmail(email.Recipient, email.Locale, email.Template, email.Data)
+		msg.MessageID = "outbox." + strconv.FormatInt(email.ID, 10)
+
+		err = app.mailer.Send(msg)
+		if err == nil {
+			err = app.models.Outbox.MarkSent(email.ID)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			continue
+		}
+
+		dead := email.Attempts >= app.config.outbox.maxAttempts
+		retryAt := time.Now().Add(jobs.Backoff(email.Attempts, app.config.outbox.backoff, app.config.outbox.maxBackoff))
+
+		properties := map[string]string{
+			"email_id":   strconv.FormatInt(email.ID, 10),
+			"template":   email.Template,
+			"message_id": msg.MessageID,
+			"attempts":   strconv.Itoa(email.Attempts),
+		}
+		if dead {
+			properties["status"] = data.OutboxDead
+		}
+		app.logger.PrintError(err, properties)
+
+		err = app.models.Outbox.MarkFailed(email.ID, err.Error(), retryAt, dead)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+
+	return len(emails)
+}
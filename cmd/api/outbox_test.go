@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/jsonlog"
+	"github.com/nimaposhtiban/greenlight/internal/mailer"
+)
+
+// fakeOutbox hands out queued emails once and records what became of them.
+type fakeOutbox struct {
+	queued     []*data.OutboxEmail
+	sent       []int64
+	suppressed []int64
+	failed     map[int64]bool
+}
+
+func (o *fakeOutbox) Enqueue(string, string, string, map[string]interface{}) error {
+	return errors.New("not implemented")
+}
+
+func (o *fakeOutbox) Claim(limit int, lease time.Duration) ([]*data.OutboxEmail, error) {
+	n := min(limit, len(o.queued))
+	claimed := o.queued[:n]
+	o.queued = o.queued[n:]
+	return claimed, nil
+}
+
+func (o *fakeOutbox) MarkSent(id int64) error {
+	o.sent = append(o.sent, id)
+	return nil
+}
+
+func (o *fakeOutbox) MarkFailed(id int64, lastError string, retryAt time.Time, dead bool) error {
+	if o.failed == nil {
+		o.failed = map[int64]bool{}
+	}
+	o.failed[id] = dead
+	return nil
+}
+
+func (o *fakeOutbox) MarkSuppressed(id int64) error {
+	o.suppressed = append(o.suppressed, id)
+	return nil
+}
+
+type fakeSuppressions map[string]bool
+
+func (s fakeSuppressions) Insert(*data.EmailSuppression) error { return nil }
+
+func (s fakeSuppressions) Suppressed(email string) (bool, error) {
+	return s[email], nil
+}
+
+func (s fakeSuppressions) GetAll(string, string, data.Filters) ([]*data.EmailSuppression, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+func (s fakeSuppressions) Delete(int64) error { return nil }
+
+func newOutboxTestApp(t *testing.T, outbox *fakeOutbox, suppressed fakeSuppressions) (*application, *mailer.MemoryMailer) {
+	t.Helper()
+
+	templates, err := mailer.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := mailer.NewMemory(templates, "Greenlight <no-reply@example.com>")
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		mailer: mail,
+	}
+	app.models.Outbox = outbox
+	app.models.Suppressions = suppressed
+	app.config.outbox.batchSize = 10
+	app.config.outbox.maxAttempts = 3
+	app.config.outbox.backoff = time.Second
+	app.config.outbox.maxBackoff = time.Minute
+	return app, mail
+}
+
+func TestDeliverOutbox(t *testing.T) {
+	outbox := &fakeOutbox{queued: []*data.OutboxEmail{
+		{
+			ID:        7,
+			Recipient: "alice@example.com",
+			Locale:    "fa",
+			Template:  "user_welcome.tmpl",
+			Data:      map[string]interface{}{"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU", "userID": 1},
+			Attempts:  1,
+		},
+		{
+			ID:        8,
+			Recipient: "bob@example.com",
+			Locale:    "en",
+			Template:  "user_lockout.tmpl",
+			Data:      map[string]interface{}{"lockout": "15m0s"},
+			Attempts:  1,
+		},
+	}}
+	app, mail := newOutboxTestApp(t, outbox, fakeSuppressions{})
+
+	n := app.deliverOutbox()
+	if n != 2 {
+		t.Fatalf("claimed %d emails, want 2", n)
+	}
+
+	messages := mail.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("sent %d emails, want 2", len(messages))
+	}
+
+	welcome := messages[0]
+	if len(welcome.To) != 1 || welcome.To[0] != "alice@example.com" {
+		t.Errorf("welcome email sent to %v", welcome.To)
+	}
+	if welcome.MessageID != "<outbox.7@example.com>" {
+		t.Errorf("welcome email has Message-ID %s", welcome.MessageID)
+	}
+	if !strings.Contains(welcome.PlainBody, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU") {
+		t.Error("welcome email does not contain the activation token")
+	}
+	if !strings.Contains(welcome.HTMLBody, `dir="rtl"`) {
+		t.Error("welcome email was not rendered in the recipient's locale")
+	}
+
+	if messages[1].To[0] != "bob@example.com" || !strings.Contains(messages[1].PlainBody, "15m0s") {
+		t.Errorf("unexpected lockout email %+v", messages[1])
+	}
+
+	if len(outbox.sent) != 2 || outbox.sent[0] != 7 || outbox.sent[1] != 8 {
+		t.Errorf("marked %v as sent, want [7 8]", outbox.sent)
+	}
+}
+
+func TestDeliverOutboxSkipsSuppressedAddresses(t *testing.T) {
+	outbox := &fakeOutbox{queued: []*data.OutboxEmail{
+		{ID: 1, Recipient: "bounced@example.com", Template: "user_lockout.tmpl", Data: map[string]interface{}{"lockout": "15m0s"}, Attempts: 1},
+		{ID: 2, Recipient: "alice@example.com", Template: "user_lockout.tmpl", Data: map[string]interface{}{"lockout": "15m0s"}, Attempts: 1},
+	}}
+	app, mail := newOutboxTestApp(t, outbox, fakeSuppressions{"bounced@example.com": true})
+
+	app.deliverOutbox()
+
+	messages := mail.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "alice@example.com" {
+		t.Fatalf("sent %+v, want only the email to alice@example.com", messages)
+	}
+	if len(outbox.suppressed) != 1 || outbox.suppressed[0] != 1 {
+		t.Errorf("marked %v as suppressed, want [1]", outbox.suppressed)
+	}
+	if len(outbox.sent) != 1 || outbox.sent[0] != 2 {
+		t.Errorf("marked %v as sent, want [2]", outbox.sent)
+	}
+}
+
+func TestDeliverOutboxRetriesFailures(t *testing.T) {
+	outbox := &fakeOutbox{queued: []*data.OutboxEmail{
+		{ID: 1, Recipient: "alice@example.com", Template: "missing.tmpl", Attempts: 1},
+		{ID: 2, Recipient: "alice@example.com", Template: "missing.tmpl", Attempts: 3},
+	}}
+	app, mail := newOutboxTestApp(t, outbox, fakeSuppressions{})
+
+	app.deliverOutbox()
+
+	if len(mail.Messages()) != 0 {
+		t.Errorf("sent %d emails, want none", len(mail.Messages()))
+	}
+	if dead, ok := outbox.failed[1]; !ok || dead {
+		t.Errorf("first failure of email 1: recorded %v, dead %v; want a retry", ok, dead)
+	}
+	if dead := outbox.failed[2]; !dead {
+		t.Error("email 2 was not given up after its last attempt")
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Create a person
+// @Description Create a new person who can be credited on movies
+// @BasePath /
+// @Tags people
+// @Accept json
+// @Produce json
+// @Param request body createPersonRequest true "Request body to create a person"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/people [post]
+func (app *application) createPersonHandler(w http.ResponseWriter, r *http.Request) {
+	var input createPersonRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	person := &data.Person{
+		Name:      input.Name,
+		BirthYear: input.BirthYear,
+		Biography: input.Biography,
+	}
+
+	v := validator.New()
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.People.Insert(person)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/people/%d", person.ID))
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"person": person}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Show a person
+// @Description returns a single person
+// @BasePath /
+// @Tags people
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal server error"
+// @Param id   path int true "id"
+// @Router /v1/people/{id} [get]
+func (app *application) showPersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	person, err := app.models.People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Update a person
+// @Description Update a person with the provided details
+// @BasePath /
+// @Tags people
+// @Accept json
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param request body updatePersonRequest true "Request body to update a person"
+// @Router /v1/people/{id} [patch]
+func (app *application) updatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	person, err := app.models.People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input updatePersonRequest
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		person.Name = *input.Name
+	}
+	if input.BirthYear != nil {
+		person.BirthYear = *input.BirthYear
+	}
+	if input.Biography != nil {
+		person.Biography = *input.Biography
+	}
+
+	v := validator.New()
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.People.Update(person)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete a person
+// @Description Delete a person, people still credited on a movie cannot be deleted
+// @BasePath /
+// @Tags people
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 409 "Person is credited on a movie"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Router /v1/people/{id} [delete]
+func (app *application) deletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.People.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrPersonInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the person is still credited on at least one movie")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "person successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return a list of people
+// @Description returns a list of people based on provided query string
+// @BasePath /
+// @Tags people
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param name   query string false "name"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/people [get]
+func (app *application) listPeopleHandler(w http.ResponseWriter, r *http.Request) {
+	var input listPeopleRequest
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.SortSafelist = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	people, metadata, err := app.models.People.GetAll(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"people": people, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return the credits of a movie
+// @Description returns the cast and crew of a movie in billing order
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Router /v1/movies/{id}/credits [get]
+func (app *application) listMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	credits, err := app.models.People.GetCredits(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Replace the credits of a movie
+// @Description replaces the whole cast and crew of a movie. billing_order defaults to the position in the array.
+// @BasePath /
+// @Tags movies
+// @Accept json
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param request body []creditRequest true "Credits of the movie"
+// @Router /v1/movies/{id}/credits [put]
+func (app *application) setMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input []creditRequest
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credits := make([]*data.Credit, len(input))
+	for i, item := range input {
+		credits[i] = &data.Credit{
+			PersonID:     item.PersonID,
+			Role:         item.Role,
+			Character:    item.Character,
+			BillingOrder: i + 1,
+		}
+		if item.BillingOrder != nil {
+			credits[i].BillingOrder = *item.BillingOrder
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateCredits(v, credits); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.People.SetCredits(id, credits)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownPerson):
+			v.AddError("credits", "must only reference existing people")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
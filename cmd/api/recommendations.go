@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Return similar movies
+// @Description returns movies sharing genres with the movie, ranked by the Jaccard similarity of their genres and then by how close their release years are
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 404 "Not found"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Router /v1/movies/{id}/similar [get]
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readRankedFilters(r, v)
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movies, metadata, err := app.models.Recommendations.Similar(movie, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return movie recommendations
+// @Description returns movies the authenticated user has not rated or watched, ranked by item-based collaborative filtering over the ratings of all users
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Router /v1/users/me/recommendations [get]
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readRankedFilters(r, v)
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	movies, metadata, err := app.models.Recommendations.ForUser(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readRankedFilters reads the pagination of a ranked list. Ranked lists are
+// always sorted by descending score.
+func (app *application) readRankedFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+
+	return data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-score"),
+		SortSafelist: []string{"-score"},
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Review a movie
+// @Description Adds a score between 1 and 10 and an optional text review of the movie by the authenticated user. Each user can review a movie once.
+// @BasePath /
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id   path int true "id"
+// @Param request body createReviewRequest true "Request body to review a movie"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 404 "Not found"
+// @Failure 409 "Movie already reviewed"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/{id}/reviews [post]
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readReviewedMovie(w, r)
+	if !ok {
+		return
+	}
+
+	var input createReviewRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	review := &data.Review{
+		MovieID:  movie.ID,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Score:    input.Score,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			app.errorResponse(w, r, http.StatusConflict, "you have already reviewed this movie")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Update a review
+// @Description Updates the authenticated user's review of the movie
+// @BasePath /
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id   path int true "id"
+// @Param request body updateReviewRequest true "Request body to update a review"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 404 "Not found"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/{id}/reviews [patch]
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readReviewedMovie(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	review, err := app.models.Reviews.GetForUser(movie.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input updateReviewRequest
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Score != nil {
+		review.Score = *input.Score
+	}
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete a review
+// @Description Deletes the authenticated user's review of the movie
+// @BasePath /
+// @Tags reviews
+// @Produce json
+// @Param id   path int true "id"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/movies/{id}/reviews [delete]
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readReviewedMovie(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err := app.models.Reviews.Delete(movie.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return the reviews of a movie
+// @Description returns a page of the reviews of a movie
+// @BasePath /
+// @Tags reviews
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 404 "Not found"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/movies/{id}/reviews [get]
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readReviewedMovie(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.SortSafelist = []string{"created_at", "score", "-created_at", "-score"}
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readReviewedMovie loads the movie named by the id parameter. When the
+// movie cannot be loaded it writes the error response and returns false.
+func (app *application) readReviewedMovie(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary List the revisions of a movie
+// @Description returns the revision history of a movie
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param include_deleted   query bool false "include soft deleted movies (requires movies:admin)"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "version or -version"
+// @Router /v1/movies/{id}/revisions [get]
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.SortSafelist = []string{"version", "-version"}
+	filters.Sort = app.readString(qs, "sort", "-version")
+	includeDeleted := app.readBool(qs, "include_deleted", false, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if includeDeleted && !app.allowDeletedMovies(w, r) {
+		return
+	}
+
+	if includeDeleted {
+		_, err = app.models.Movies.GetIncludingDeleted(id)
+	} else {
+		_, err = app.models.Movies.Get(id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.GetRevisions(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Show a revision of a movie
+// @Description returns the complete state of a movie at the given version
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param version   path int true "version"
+// @Param include_deleted   query bool false "include soft deleted movies (requires movies:admin)"
+// @Router /v1/movies/{id}/revisions/{version} [get]
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, version, err := app.readRevisionParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	includeDeleted := app.readBool(r.URL.Query(), "include_deleted", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if includeDeleted && !app.allowDeletedMovies(w, r) {
+		return
+	}
+
+	if includeDeleted {
+		_, err = app.models.Movies.GetIncludingDeleted(id)
+	} else {
+		_, err = app.models.Movies.Get(id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Roll a movie back to an earlier version
+// @Description copies the state of the given version onto the movie and saves it as a new version
+// @BasePath /
+// @Tags movies
+// @Produce json
+// @Success 200  {object}  getMovieResult
+// @Failure 404 "Not found"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Unprocessable Entity"
+// @Failure 500 "Internal Server Error"
+// @Param id   path int true "id"
+// @Param version   path int true "version"
+// @Router /v1/movies/{id}/revisions/{version}/rollback [post]
+func (app *application) rollbackMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, version, err := app.readRevisionParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Genres may have been renamed, merged or deleted since the revision was
+	// written, so the snapshot goes through the same checks as an update.
+	revision.ApplyTo(movie)
+
+	catalog, err := app.models.Genres.Catalog()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = catalog.Normalize(movie.Genres)
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie, catalog); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Rollback(movie, app.contextGetUserID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readRevisionParams(r *http.Request) (int64, int32, error) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	version, err := app.readInt64Param(r, "version")
+	if err != nil {
+		return 0, 0, err
+	}
+	if version > math.MaxInt32 {
+		return 0, 0, errors.New("invalid version parameter")
+	}
+
+	return id, int32(version), nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/julienschmidt/httprouter"
 	_ "github.com/nimaposhtiban/greenlight/cmd/api/docs"
+	"github.com/nimaposhtiban/greenlight/internal/data"
 	"net/http"
 
 	"github.com/swaggo/http-swagger/v2"
@@ -27,8 +28,98 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission(data.PermissionMoviesAdmin, app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.listMovieCreditsHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission(data.PermissionPeopleAdmin, app.setMovieCreditsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.listMovieRevisionsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.showMovieRevisionHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/rollback", app.rollbackMovieHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.listSimilarMoviesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.listReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.createReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.listGenresHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission(data.PermissionGenresAdmin, app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.showGenreHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission(data.PermissionGenresAdmin, app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requirePermission(data.PermissionGenresAdmin, app.deleteGenreHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.listPeopleHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission(data.PermissionPeopleAdmin, app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.showPersonHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission(data.PermissionPeopleAdmin, app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission(data.PermissionPeopleAdmin, app.deletePersonHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	if app.oidc != nil {
+		router.HandlerFunc(http.MethodGet, "/v1/auth/oidc/login", app.oidcLoginHandler)
+		router.HandlerFunc(http.MethodGet, "/v1/auth/oidc/callback", app.oidcCallbackHandler)
+		router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.createTwoFactorTokenHandler)
+	}
+
+	if app.config.emails.webhookSecret != "" {
+		router.HandlerFunc(http.MethodPost, "/v1/webhooks/email", app.emailNotificationHandler)
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireNoAPIKey(app.requireAuthenticatedUser(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireNoAPIKey(app.requireAuthenticatedUser(app.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireNoAPIKey(app.requireAuthenticatedUser(app.changePasswordHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireNoAPIKey(app.requireActivatedUser(app.beginTOTPEnrolmentHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp/confirm", app.requireNoAPIKey(app.requireActivatedUser(app.confirmTOTPEnrolmentHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireNoAPIKey(app.requireActivatedUser(app.deleteTOTPEnrolmentHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireActivatedUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireNoAPIKey(app.requireActivatedUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireNoAPIKey(app.requireActivatedUser(app.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireNoAPIKey(app.requireAuthenticatedUser(app.listSessionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireNoAPIKey(app.requireAuthenticatedUser(app.deleteAllSessionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireNoAPIKey(app.requireAuthenticatedUser(app.deleteSessionHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission(data.PermissionUsersAdmin, app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission(data.PermissionUsersAdmin, app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission(data.PermissionUsersAdmin, app.setUserActivatedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission(data.PermissionUsersAdmin, app.revokeUserTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission(data.PermissionUsersAdmin, app.grantPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission(data.PermissionUsersAdmin, app.revokePermissionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs", app.requirePermission(data.PermissionJobsAdmin, app.listJobsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/email-suppressions", app.requirePermission(data.PermissionEmailsAdmin, app.listEmailSuppressionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/email-suppressions/:id", app.requirePermission(data.PermissionEmailsAdmin, app.deleteEmailSuppressionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requireActivatedUser(app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requireActivatedUser(app.addWatchlistItemHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/watchlist/:id", app.requireActivatedUser(app.moveWatchlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requireActivatedUser(app.removeWatchlistItemHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/history", app.requireActivatedUser(app.listWatchHistoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/history", app.requireActivatedUser(app.createWatchEventHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/history/:id", app.requireActivatedUser(app.deleteWatchEventHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requireActivatedUser(app.listRecommendationsHandler))
+
+	// httprouter does not allow a static segment such as /v1/movies/bulk to
+	// share a position with the /v1/movies/:id wildcard, so collection-level
+	// actions live on a second router that is consulted first.
+	actions := httprouter.New()
+	actions.HandlerFunc(http.MethodPost, "/v1/movies/bulk", app.bulkCreateMoviesHandler)
+	actions.HandlerFunc(http.MethodPatch, "/v1/movies/bulk", app.bulkUpdateMoviesHandler)
+	actions.HandlerFunc(http.MethodDelete, "/v1/movies/bulk", app.bulkDeleteMoviesHandler)
+	actions.HandlerFunc(http.MethodGet, "/v1/movies/export", app.exportMoviesHandler)
+	actions.HandlerFunc(http.MethodPost, "/v1/movies/import", app.importMoviesHandler)
+
+	return app.recoverPanic(app.rateLimit(app.authenticate(dispatch(actions, router))))
+}
 
-	return app.recoverPanic(app.rateLimit(router))
+// dispatch serves a request from primary when it has a matching route and
+// falls back to fallback otherwise.
+func dispatch(primary *httprouter.Router, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, params, _ := primary.Lookup(r.Method, r.URL.Path); handle != nil {
+			handle(w, r, params)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
 }
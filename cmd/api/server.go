@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,6 +21,7 @@ func (app *application) server() error {
 		WriteTimeout: 30 * time.Second,
 	}
 	shutdownError := make(chan error)
+	stopMaintenance := make(chan struct{})
 
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -39,11 +41,23 @@ func (app *application) server() error {
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
+		close(stopMaintenance)
 
 		app.wg.Wait()
+
+		if closer, ok := app.mailer.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
 		shutdownError <- nil
 	}()
 
+	app.startMaintenance(stopMaintenance)
+	app.startOutbox(stopMaintenance)
+	app.startJobs(stopMaintenance)
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
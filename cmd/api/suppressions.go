@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+const (
+	bounceHard = "hard"
+	bounceSoft = "soft"
+)
+
+// @Summary Report a bounce or complaint
+// @Description Takes a bounce or spam complaint notification relayed from the mail provider. Hard bounces and complaints put the address on the suppression list, and a hard bounce also marks an unactivated account with the address as undeliverable. Soft bounces are only logged. The request must carry an X-Webhook-Signature header of the form sha256=<hex>, the HMAC-SHA256 of the raw body keyed with the webhook secret.
+// @BasePath /
+// @Tags emails
+// @Accept json
+// @Produce json
+// @Param X-Webhook-Signature header string true "sha256=<hex HMAC-SHA256 of the body>"
+// @Param request body emailNotificationRequest true "Notification"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Invalid signature"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/webhooks/email [post]
+func (app *application) emailNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_071_576))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.validWebhookSignature(r.Header.Get("X-Webhook-Signature"), body) {
+		app.invalidWebhookSignatureResponse(w, r)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var input emailNotificationRequest
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Type, data.SuppressionBounce, data.SuppressionComplaint), "type", "must be bounce or complaint")
+	if input.Type == data.SuppressionBounce {
+		v.Check(validator.In(input.BounceType, bounceHard, bounceSoft), "bounce_type", "must be hard or soft")
+	}
+
+	suppression := &data.EmailSuppression{
+		Email:     input.Email,
+		Reason:    input.Type,
+		MessageID: input.MessageID,
+		Detail:    input.Detail,
+	}
+
+	if data.ValidateEmailSuppression(v, suppression); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	properties := map[string]string{
+		"type":       input.Type,
+		"email":      input.Email,
+		"message_id": input.MessageID,
+		"detail":     input.Detail,
+	}
+	if input.Type == data.SuppressionBounce {
+		properties["bounce_type"] = input.BounceType
+	}
+	app.logger.PrintInfo("email notification received", properties)
+
+	// A soft bounce, such as a full mailbox, may clear up on its own, so the
+	// address is kept.
+	if input.BounceType == bounceSoft {
+		err = app.writeJson(w, http.StatusOK, envelope{"message": "soft bounce recorded"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Suppressions.Insert(suppression)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"suppression": suppression}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validWebhookSignature reports whether signature is the HMAC-SHA256 of body
+// keyed with the webhook secret, as sha256=<hex>.
+func (app *application) validWebhookSignature(signature string, body []byte) bool {
+	sum, found := strings.CutPrefix(signature, "sha256=")
+	if !found {
+		return false
+	}
+
+	got, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(app.config.emails.webhookSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// @Summary Return the email suppression list
+// @Description returns a page of addresses that no email is sent to, optionally filtered by a search on the address and by reason
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param search   query string false "search"
+// @Param reason   query string false "reason" Enums(bounce, complaint)
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/admin/email-suppressions [get]
+func (app *application) listEmailSuppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input listEmailSuppressionsRequest
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.Reason = app.readString(qs, "reason", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.SortSafelist = []string{"created_at", "email", "-created_at", "-email"}
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	if input.Reason != "" {
+		v.Check(validator.In(input.Reason, data.SuppressionBounce, data.SuppressionComplaint), "reason", "invalid reason value")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suppressions, metadata, err := app.models.Suppressions.GetAll(input.Search, input.Reason, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"suppressions": suppressions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Remove an address from the suppression list
+// @Description emails are sent to the address again, and an account using it is no longer marked as undeliverable
+// @BasePath /
+// @Tags admin
+// @Produce json
+// @Param id   path int true "id"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Forbidden"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/admin/email-suppressions/{id} [delete]
+func (app *application) deleteEmailSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Suppressions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "suppression successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -32,14 +32,162 @@ type updateMovieRequest struct {
 	Genres  []string      `json:"genres" maximum:"5"`
 }
 
+type bulkUpdateMovieRequest struct {
+	ID      int64  `json:"id" validate:"required"`
+	Version *int32 `json:"version"`
+	updateMovieRequest
+}
+
 type listMoviesRequest struct {
-	Title  string
-	Genres []string
+	Title          string
+	Genres         []string
+	PersonID       int64
+	IncludeDeleted bool
+	Include        map[string]bool
 	data.Filters
 }
 
+type createGenreRequest struct {
+	Name    string   `json:"name" validate:"required" maximum:"100"`
+	Slug    *string  `json:"slug" example:"science-fiction"`
+	Aliases []string `json:"aliases" maximum:"20"`
+}
+
+type updateGenreRequest struct {
+	Name    *string  `json:"name" maximum:"100"`
+	Slug    *string  `json:"slug" example:"science-fiction"`
+	Aliases []string `json:"aliases" maximum:"20"`
+}
+
+type listGenresRequest struct {
+	Name string
+	data.Filters
+}
+
+type createPersonRequest struct {
+	Name      string `json:"name" validate:"required" maximum:"500"`
+	BirthYear int32  `json:"birth_year" minimum:"1800"`
+	Biography string `json:"biography" maximum:"10000"`
+}
+
+type updatePersonRequest struct {
+	Name      *string `json:"name" maximum:"500"`
+	BirthYear *int32  `json:"birth_year" minimum:"1800"`
+	Biography *string `json:"biography" maximum:"10000"`
+}
+
+type listPeopleRequest struct {
+	Name string
+	data.Filters
+}
+
+type creditRequest struct {
+	PersonID     int64  `json:"person_id" validate:"required"`
+	Role         string `json:"role" validate:"required" enums:"director,writer,actor"`
+	Character    string `json:"character" maximum:"500"`
+	BillingOrder *int   `json:"billing_order" minimum:"1"`
+}
+
 type registerUserRequest struct {
 	Name     string `json:"name" validate:"required" maximum:"500"`
 	Email    string `json:"email" validate:"required"`
-	Password string `json:"password" validate:"required" maximum:"72"`
+	Password string `json:"password" validate:"required" maximum:"1024"`
+	Locale   string `json:"locale" example:"fa"`
+}
+
+type createAuthenticationTokenRequest struct {
+	Email        string `json:"email" validate:"required"`
+	Password     string `json:"password" validate:"required" maximum:"1024"`
+	TOTPCode     string `json:"totp_code" example:"123456"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type createTwoFactorTokenRequest struct {
+	Token        string `json:"token" validate:"required" example:"Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"`
+	TOTPCode     string `json:"totp_code" example:"123456"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type createReviewRequest struct {
+	Score int    `json:"score" validate:"required" minimum:"1" maximum:"10"`
+	Body  string `json:"body" maximum:"10000"`
+}
+
+type updateReviewRequest struct {
+	Score *int    `json:"score" minimum:"1" maximum:"10"`
+	Body  *string `json:"body" maximum:"10000"`
+}
+
+type activateUserRequest struct {
+	TokenPlaintext string `json:"token" validate:"required" example:"Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"`
+}
+
+type addWatchlistItemRequest struct {
+	MovieID int64 `json:"movie_id" validate:"required"`
+}
+
+type moveWatchlistItemRequest struct {
+	Position int `json:"position" validate:"required" minimum:"1"`
+}
+
+type createWatchEventRequest struct {
+	MovieID   int64      `json:"movie_id" validate:"required"`
+	WatchedAt *time.Time `json:"watched_at"`
+}
+
+type updateProfileRequest struct {
+	Name   *string `json:"name" maximum:"500"`
+	Email  *string `json:"email"`
+	Locale *string `json:"locale" example:"fa"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required" minimum:"8" maximum:"1024"`
+}
+
+type listUsersRequest struct {
+	Search    string
+	Activated *bool
+	data.Filters
+}
+
+type listJobsRequest struct {
+	Status string
+	Kind   string
+	data.Filters
+}
+
+type listEmailSuppressionsRequest struct {
+	Search string
+	Reason string
+	data.Filters
+}
+
+// emailNotificationRequest is the provider independent format of bounce and
+// complaint notifications.
+type emailNotificationRequest struct {
+	Type       string `json:"type" validate:"required" enums:"bounce,complaint"`
+	Email      string `json:"email" validate:"required" example:"alice@example.com"`
+	BounceType string `json:"bounce_type" enums:"hard,soft"`
+	MessageID  string `json:"message_id" maximum:"500" example:"<outbox.42@example.com>"`
+	Detail     string `json:"detail" maximum:"1000" example:"550 5.1.1 user unknown"`
+}
+
+type setUserActivatedRequest struct {
+	Activated bool `json:"activated"`
+}
+
+type grantPermissionsRequest struct {
+	Permissions []string `json:"permissions" validate:"required" example:"users:admin"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code" validate:"required" example:"123456"`
+}
+
+type createAPIKeyRequest struct {
+	Name        string     `json:"name" validate:"required" maximum:"100"`
+	Permissions []string   `json:"permissions" example:"users:admin"`
+	ExpiresAt   *time.Time `json:"expires_at"`
 }
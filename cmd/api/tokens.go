@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Create an authentication token
+// @Description Exchanges an email address and password for a bearer token that is valid for 24 hours. Users enrolled in two-factor authentication also need a totp_code or a recovery_code. Repeated failures lock the account and the client for a while.
+// @BasePath /
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param request body createAuthenticationTokenRequest true "Credentials of the user"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Invalid credentials"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/tokens/authentication [post]
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input createAuthenticationTokenRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlainText(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accountKey := data.AccountLoginKey(input.Email)
+	ipKey := data.IPLoginKey(ip)
+
+	// Locked accounts and clients get the same response as a wrong password,
+	// so that a lockout does not reveal whether the account exists.
+	locked, err := app.models.LoginFailures.Locked(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if locked {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			data.SimulatePasswordCheck(input.Password)
+			app.recordLoginFailure(w, r, nil, accountKey, ipKey)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.recordLoginFailure(w, r, user, accountKey, ipKey)
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrolment != nil && enrolment.Confirmed {
+		if input.TOTPCode == "" && input.RecoveryCode == "" {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+
+		ok, err := app.checkSecondFactor(enrolment, input.TOTPCode, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			app.recordLoginFailure(w, r, user, accountKey, ipKey)
+			return
+		}
+	}
+
+	err = app.models.LoginFailures.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The plaintext is only at hand now, so this is the moment to move the
+	// hash to the current algorithm. Failing to do so must not fail the login.
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(user)
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	token, err := app.newSession(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordLoginFailure counts a failed login with countLoginFailure and sends
+// the invalid credentials response.
+func (app *application) recordLoginFailure(w http.ResponseWriter, r *http.Request, user *data.User, accountKey, ipKey string) {
+	err := app.countLoginFailure(r, user, accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// countLoginFailure counts a failed attempt at a password or second factor
+// against the account and the client IP, and emails the user when their
+// account has just been locked.
+func (app *application) countLoginFailure(r *http.Request, user *data.User, accountKey, ipKey string) error {
+	accountPolicy := data.LockoutPolicy{
+		MaxFailures: app.config.auth.maxFailures,
+		BaseDelay:   app.config.auth.backoff,
+		Lockout:     app.config.auth.lockout,
+		Window:      app.config.auth.window,
+	}
+
+	ipPolicy := accountPolicy
+	ipPolicy.MaxFailures = app.config.auth.ipMaxFailures
+	ipPolicy.BaseDelay = 0
+
+	locked, err := app.models.LoginFailures.RecordFailure(accountKey, accountPolicy)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.models.LoginFailures.RecordFailure(ipKey, ipPolicy)
+	if err != nil {
+		return err
+	}
+
+	if locked && user != nil {
+		err = app.models.Outbox.Enqueue(user.Email, user.Locale, "user_lockout.tmpl", map[string]interface{}{
+			"lockout": app.config.auth.lockout.String(),
+		})
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	return nil
+}
+
+// checkReauthentication guards endpoints where a signed in user proves again
+// who they are, such as with their current password or a second factor code.
+// Failures there count towards the same lockout as failed logins, so a stolen
+// session cannot be used to guess them. It returns the lockout keys of the
+// user, or sends the error response and returns ok false when the account or
+// client is locked.
+func (app *application) checkReauthentication(w http.ResponseWriter, r *http.Request, user *data.User) (accountKey, ipKey string, ok bool) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return "", "", false
+	}
+
+	accountKey = data.AccountLoginKey(user.Email)
+	ipKey = data.IPLoginKey(ip)
+
+	locked, err := app.models.LoginFailures.Locked(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return "", "", false
+	}
+	if locked {
+		app.tooManyFailuresResponse(w, r)
+		return "", "", false
+	}
+
+	return accountKey, ipKey, true
+}
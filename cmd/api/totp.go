@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/totp"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Start two-factor enrolment
+// @Description Generates a new TOTP secret for the authenticated user and returns it with an otpauth:// URI for authenticator apps. The enrolment has to be confirmed with a first code before it protects logins.
+// @BasePath /
+// @Tags users
+// @Produce json
+// @Success 201 "Created"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 409 "Already enrolled"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/totp [post]
+func (app *application) beginTOTPEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	enrolment := &data.TOTP{UserID: user.ID, Secret: secret}
+
+	err = app.models.TOTP.Begin(enrolment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnrolled):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret": secret,
+		"uri":    totp.URI("Greenlight", user.Email, secret),
+	}
+
+	err = app.writeJson(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Confirm two-factor enrolment
+// @Description Confirms the enrolment of the authenticated user with a first code and returns one-time recovery codes. The recovery codes are only shown once.
+// @BasePath /
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body totpCodeRequest true "Code from the authenticator app"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 409 "Already enrolled"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/totp/confirm [post]
+func (app *application) confirmTOTPEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input totpCodeRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrolment.Confirmed {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	v := validator.New()
+
+	step, ok := totp.Verify(enrolment.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is invalid or expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.TOTP.Confirm(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnrolled):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Disable two-factor authentication
+// @Description Removes the two-factor enrolment and recovery codes of the authenticated user. Confirmed enrolments need a current code or an unused recovery code, and wrong codes count towards the lockout of the account like failed logins.
+// @BasePath /
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body totpCodeRequest true "Code from the authenticator app or a recovery code"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 422 "Failed Model Validation"
+// @Failure 429 "Too many failed attempts"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/totp [delete]
+func (app *application) deleteTOTPEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input totpCodeRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrolment.Confirmed {
+		accountKey, ipKey, ok := app.checkReauthentication(w, r, user)
+		if !ok {
+			return
+		}
+
+		code, recoveryCode := input.Code, ""
+		if len(code) != totp.Digits {
+			code, recoveryCode = "", input.Code
+		}
+
+		ok, err := app.checkSecondFactor(enrolment, code, recoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			err = app.countLoginFailure(r, user, accountKey, ipKey)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			v := validator.New()
+			v.AddError("code", "is invalid or expired")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.models.LoginFailures.Reset(accountKey)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkSecondFactor checks a TOTP code, or a recovery code when no TOTP code
+// is given, against a confirmed enrolment. Accepted codes are spent so that
+// they cannot be replayed.
+func (app *application) checkSecondFactor(enrolment *data.TOTP, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Verify(enrolment.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return app.models.TOTP.UseStep(enrolment.UserID, step)
+	}
+
+	recoveryCode = strings.ToUpper(strings.ReplaceAll(recoveryCode, "-", ""))
+	return app.models.TOTP.UseRecoveryCode(enrolment.UserID, recoveryCode)
+}
@@ -5,10 +5,11 @@ import (
 	"github.com/nimaposhtiban/greenlight/internal/data"
 	"github.com/nimaposhtiban/greenlight/internal/validator"
 	"net/http"
+	"time"
 )
 
 // @Summary Register a new user
-// @Description Registers a new user with the provided info then sends an welcome email to new user
+// @Description Registers a new user with the provided info then sends an welcome email with an activation token to new user
 // @BasePath /
 // @Tags users
 // @Accept json
@@ -33,6 +34,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Locale:    input.Locale,
+	}
+	if user.Locale == "" {
+		user.Locale = data.DefaultLocale
 	}
 
 	err = user.Password.Set(input.Password)
@@ -42,12 +47,14 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 	v := validator.New()
 
-	if data.ValidateUser(v, user); !v.Valid() {
+	data.ValidateUser(v, user)
+	app.passwordPolicy.Check(v, input.Password, user)
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	_, err = app.models.Users.Register(user, 3*24*time.Hour, "user_welcome.tmpl")
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -57,16 +64,283 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Activate a user
+// @Description Activates the user who owns the activation token sent in the welcome email
+// @BasePath /
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body activateUserRequest true "Activation token"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/activated [put]
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input activateUserRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Activated = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Show the current user
+// @Description returns the authenticated user
+// @BasePath /
+// @Tags users
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Router /v1/users/me [get]
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Update the current user
+// @Description Updates the name, email address and locale of the authenticated user. Changing the email address deactivates the account and sends an activation token to the new address.
+// @BasePath /
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body updateProfileRequest true "Request body to update the user"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "API key"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me [patch]
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input updateProfileRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	emailChanged := false
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.Locale != nil {
+		user.Locale = *input.Locale
+	}
+	if input.Email != nil && *input.Email != user.Email {
+		user.Email = *input.Email
+		user.Activated = false
+		user.EmailUndeliverable = false
+		emailChanged = true
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if emailChanged {
+		_, err = app.models.Users.ChangeEmail(user, 3*24*time.Hour, "user_email_change.tmpl")
+	} else {
+		err = app.models.Users.Update(user)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	app.background(func() {
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", user)
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Change the password of the current user
+// @Description Replaces the password of the authenticated user after checking the current one. All authentication tokens of the user are revoked. Wrong current passwords count towards the lockout of the account like failed logins.
+// @BasePath /
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body changePasswordRequest true "Current and new password"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "API key"
+// @Failure 409 "Edit conflict"
+// @Failure 422 "Failed Model Validation"
+// @Failure 429 "Too many failed attempts"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/password [put]
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input changePasswordRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	accountKey, ipKey, ok := app.checkReauthentication(w, r, user)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		err = app.countLoginFailure(r, user, accountKey, ipKey)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
 
-	err = app.writeJson(w, http.StatusAccepted, envelope{"user": user}, nil)
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.LoginFailures.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateUser(v, user)
+	app.passwordPolicy.Check(v, input.NewPassword, user)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "password successfully changed, please authenticate again"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete the current user
+// @Description Deletes the account of the authenticated user. Their reviews are kept but anonymised, everything else they own is removed.
+// @BasePath /
+// @Tags users
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 409 "Edit conflict"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me [delete]
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Users.Anonymise(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
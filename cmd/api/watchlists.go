@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/data"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// @Summary Return the watchlist
+// @Description returns a page of the authenticated user's watchlist
+// @BasePath /
+// @Tags watchlist
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/users/me/watchlist [get]
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.SortSafelist = []string{"position", "added_at", "title", "year", "-position", "-added_at", "-title", "-year"}
+	filters.Sort = app.readString(qs, "sort", "position")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	items, metadata, err := app.models.Watchlists.GetAll(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"watchlist": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Add a movie to the watchlist
+// @Description adds a movie to the end of the authenticated user's watchlist
+// @BasePath /
+// @Tags watchlist
+// @Accept json
+// @Produce json
+// @Param request body addWatchlistItemRequest true "Movie to add"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 409 "Movie already on the watchlist"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/watchlist [post]
+func (app *application) addWatchlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input addWatchlistItemRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	item, err := app.models.Watchlists.Add(user.ID, movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateWatchlistItem):
+			app.errorResponse(w, r, http.StatusConflict, "the movie is already on your watchlist")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Reorder the watchlist
+// @Description moves a movie to another position of the authenticated user's watchlist
+// @BasePath /
+// @Tags watchlist
+// @Accept json
+// @Produce json
+// @Param id   path int true "movie id"
+// @Param request body moveWatchlistItemRequest true "New position"
+// @Success 200 "Ok"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/watchlist/{id} [patch]
+func (app *application) moveWatchlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input moveWatchlistItemRequest
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Position > 0, "position", "must be greater than zero"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	position, err := app.models.Watchlists.Move(user.ID, id, input.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"movie_id": id, "position": position}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Remove a movie from the watchlist
+// @Description removes a movie from the authenticated user's watchlist
+// @BasePath /
+// @Tags watchlist
+// @Produce json
+// @Param id   path int true "movie id"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/watchlist/{id} [delete]
+func (app *application) removeWatchlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Watchlists.Remove(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "movie successfully removed from the watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Return the watch history
+// @Description returns a page of the movies the authenticated user has watched
+// @BasePath /
+// @Tags watchlist
+// @Produce json
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 422 "Invalid request"
+// @Failure 500 "Internal Server Error"
+// @Param page   query int false "page"
+// @Param page_size   query int false "page_size"
+// @Param sort   query string false "sort"
+// @Router /v1/users/me/history [get]
+func (app *application) listWatchHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.SortSafelist = []string{"watched_at", "title", "-watched_at", "-title"}
+	filters.Sort = app.readString(qs, "sort", "-watched_at")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	events, metadata, err := app.models.Watchlists.GetHistory(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"history": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Mark a movie as watched
+// @Description adds a movie to the authenticated user's watch history and removes it from their watchlist. watched_at defaults to now.
+// @BasePath /
+// @Tags watchlist
+// @Accept json
+// @Produce json
+// @Param request body createWatchEventRequest true "Watched movie"
+// @Success 201 "Created"
+// @Failure 400 "Bad Request"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 422 "Failed Model Validation"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/history [post]
+func (app *application) createWatchEventHandler(w http.ResponseWriter, r *http.Request) {
+	var input createWatchEventRequest
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.WatchedAt != nil {
+		v.Check(input.WatchedAt.Before(time.Now().Add(time.Minute)), "watched_at", "must not be in the future")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	event := &data.WatchEvent{Movie: movie}
+	if input.WatchedAt != nil {
+		event.WatchedAt = *input.WatchedAt
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Watchlists.MarkWatched(user.ID, event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"event": event}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete a watch history entry
+// @Description removes an entry from the authenticated user's watch history
+// @BasePath /
+// @Tags watchlist
+// @Produce json
+// @Param id   path int true "id"
+// @Success 200 "Ok"
+// @Failure 401 "Unauthorized"
+// @Failure 403 "Inactive account"
+// @Failure 404 "Not found"
+// @Failure 500 "Internal Server Error"
+// @Router /v1/users/me/history/{id} [delete]
+func (app *application) deleteWatchEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Watchlists.DeleteEvent(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "history entry successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// apiKeyEncoding is lower case so that keys are easy to tell apart from the
+// authentication tokens.
+var apiKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIKey is a long-lived credential for scripts, owned by a user. A key is
+// "gl_<prefix>_<secret>"; only the prefix and a hash of the whole key are
+// stored. Requests made with a key only get the permissions listed on the
+// key that the owner still has.
+type APIKey struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      int64      `json:"-"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Plaintext   string     `json:"key,omitempty"`
+	Hash        []byte     `json:"-"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 25)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	encoded := apiKeyEncoding.EncodeToString(randomBytes)
+	key.Prefix = encoded[:8]
+	key.Plaintext = "gl_" + key.Prefix + "_" + encoded[8:]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, owner Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(key.ExpiresAt == nil || key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, permission := range key.Permissions {
+		v.Check(owner.Include(permission), "permissions", "must only contain permissions you have")
+	}
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(strings.HasPrefix(plaintext, "gl_"), "key", "must be a Greenlight API key")
+	v.Check(len(plaintext) == 44, "key", "must be 44 bytes long")
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert generates the secret of key and stores it. The plaintext key is
+// only available on the returned key.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Permissions), key.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForPlaintext returns the unexpired key matching plaintext and records
+// that it was used.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `UPDATE api_keys
+	SET last_used_at = NOW()
+	WHERE hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	RETURNING id, created_at, user_id, name, prefix, permissions, expires_at, last_used_at`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Permissions),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `SELECT id, created_at, user_id, name, prefix, permissions, expires_at, last_used_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete revokes a key of the user.
+func (m APIKeyModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditUserActivated     = "user.activated"
+	AuditUserDeactivated   = "user.deactivated"
+	AuditTokensRevoked     = "user.tokens_revoked"
+	AuditPermissionGranted = "user.permission_granted"
+	AuditPermissionRevoked = "user.permission_revoked"
+)
+
+// AuditEntry records an administrative action taken by ActorID on the user
+// TargetUserID.
+type AuditEntry struct {
+	ID           int64                  `json:"id"`
+	CreatedAt    time.Time              `json:"created_at"`
+	ActorID      int64                  `json:"actor_id"`
+	Action       string                 `json:"action"`
+	TargetUserID int64                  `json:"target_user_id"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return insertAudit(ctx, m.DB, entry)
+}
+
+// insertAudit records entry. Administrative actions call it inside their own
+// transaction, so that an action is never applied without its audit row.
+func insertAudit(ctx context.Context, q dbtx, entry *AuditEntry) error {
+	query := `INSERT INTO audit_log (actor_id, action, target_user_id, details)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	args := []interface{}{entry.ActorID, entry.Action, entry.TargetUserID, details}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
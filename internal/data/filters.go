@@ -46,6 +46,17 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// Bounds returns the LIMIT and OFFSET of the requested page, for queries that
+// live outside this package.
+func (f Filters) Bounds() (int, int) {
+	return f.limit(), f.offset()
+}
+
+// Metadata returns the pagination metadata of the requested page.
+func (f Filters) Metadata(totalRecords int) Metadata {
+	return calculateMetadata(totalRecords, f.Page, f.PageSize)
+}
+
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
 	PageSize     int `json:"page_size,omitempty"`
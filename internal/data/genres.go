@@ -0,0 +1,359 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// Genre is an entry of the managed genre catalogue. Movies store the
+// canonical Name, while the Slug and Aliases are only used to map free-text
+// input such as "sci-fi" or "Science Fiction" onto it.
+type Genre struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"-"`
+	Slug       string    `json:"slug"`
+	Name       string    `json:"name"`
+	Aliases    []string  `json:"aliases"`
+	MovieCount int       `json:"movie_count"`
+	Version    int32     `json:"version"`
+}
+
+// Slugify lower-cases s and collapses every run of characters other than
+// ASCII letters and digits into a single dash. It must stay in step with the
+// genre_slug SQL function.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(genre.Slug != "", "slug", "must contain at least one letter or digit")
+	v.Check(genre.Slug == Slugify(genre.Slug), "slug", "must only contain lower case letters, digits and dashes")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+
+	slugs := []string{genre.Slug}
+	for _, alias := range genre.Aliases {
+		v.Check(Slugify(alias) != "", "aliases", "must contain at least one letter or digit each")
+		slugs = append(slugs, Slugify(alias))
+	}
+	v.Check(validator.Unique(slugs), "aliases", "must not repeat the slug or each other")
+}
+
+// GenreCatalog maps every spelling of every known genre onto its canonical
+// name.
+type GenreCatalog struct {
+	names map[string]string
+}
+
+// Normalize replaces each known genre with its canonical name and drops the
+// duplicates this produces. Unknown genres are kept as they are so that
+// ValidateMovie can report them.
+func (c *GenreCatalog) Normalize(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, genre := range genres {
+		if name, ok := c.names[Slugify(genre)]; ok {
+			genre = name
+		}
+		if !seen[genre] {
+			seen[genre] = true
+			normalized = append(normalized, genre)
+		}
+	}
+	return normalized
+}
+
+// Contains reports whether genre is the canonical name of a known genre.
+func (c *GenreCatalog) Contains(genre string) bool {
+	return c.names[Slugify(genre)] == genre
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Catalog loads the whole genre catalogue for normalising movie input.
+func (m GenreModel) Catalog() (*GenreCatalog, error) {
+	query := `SELECT slug, name, aliases FROM genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalog := &GenreCatalog{names: make(map[string]string)}
+	for rows.Next() {
+		var slug, name string
+		var aliases []string
+		err := rows.Scan(&slug, &name, pq.Array(&aliases))
+		if err != nil {
+			return nil, err
+		}
+		catalog.names[slug] = name
+		catalog.names[Slugify(name)] = name
+		for _, alias := range aliases {
+			catalog.names[Slugify(alias)] = name
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `INSERT INTO genres (slug, name, aliases)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := checkGenreSpellings(ctx, tx, genre)
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{genre.Slug, genre.Name, pq.Array(genre.Aliases)}
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+		if err != nil {
+			return genreError(err)
+		}
+		return nil
+	})
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT g.id, g.created_at, g.slug, g.name, g.aliases, g.version,
+		(SELECT count(*) FROM movies m WHERE m.genres @> ARRAY[g.name] AND m.deleted_at IS NULL)
+	FROM genres g
+	WHERE g.id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.Version,
+		&genre.MovieCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &genre, nil
+}
+
+// Update saves changes to a genre. When the canonical name changes, every
+// movie using the old name is rewritten in the same transaction and gets a
+// new revision made by userID.
+func (m GenreModel) Update(genre *Genre, oldName string, userID *int64) error {
+	query := `UPDATE genres
+	SET slug = $1, name = $2, aliases = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := checkGenreSpellings(ctx, tx, genre)
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{genre.Slug, genre.Name, pq.Array(genre.Aliases), genre.ID, genre.Version}
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return genreError(err)
+			}
+		}
+
+		if oldName == genre.Name {
+			return nil
+		}
+
+		query := `WITH renamed AS (
+			UPDATE movies
+			SET genres = array_replace(genres, $1, $2), version = version + 1
+			WHERE genres @> ARRAY[$1::text]
+			RETURNING id, version, title, year, runtime, genres, deleted_at
+		)
+		INSERT INTO movie_revisions (movie_id, version, change_type, user_id, title, year, runtime, genres, deleted_at)
+		SELECT id, version, $3, $4::bigint, title, year, runtime, genres, deleted_at
+		FROM renamed`
+
+		_, err = tx.ExecContext(ctx, query, oldName, genre.Name, RevisionUpdate, userID)
+		return err
+	})
+}
+
+// Delete removes a genre from the catalogue. Genres that are still used by a
+// movie, including soft deleted ones, cannot be deleted.
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM genres g
+	WHERE g.id = $1
+	AND NOT EXISTS (SELECT 1 FROM movies m WHERE m.genres @> ARRAY[g.name])
+	RETURNING g.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Tell a missing genre apart from one that is still in use.
+			_, err := m.Get(id)
+			if err != nil {
+				return err
+			}
+			return ErrGenreInUse
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll returns a page of genres with the number of movies using each one.
+func (m GenreModel) GetAll(name string, filters Filters) ([]*Genre, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), g.id, g.created_at, g.slug, g.name, g.aliases, g.version,
+		(SELECT count(*) FROM movies m WHERE m.genres @> ARRAY[g.name] AND m.deleted_at IS NULL) AS movie_count
+	FROM genres g
+	WHERE (g.name ILIKE '%%' || $1 || '%%' OR $1 = '')
+	ORDER BY %s %s, g.id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(
+			&totalRecords,
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Slug,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.Version,
+			&genre.MovieCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return genres, metadata, nil
+}
+
+// checkGenreSpellings makes sure none of the slug, name or aliases of genre
+// can be confused with a spelling of another genre.
+func checkGenreSpellings(ctx context.Context, q dbtx, genre *Genre) error {
+	slugs := []string{genre.Slug, Slugify(genre.Name)}
+	for _, alias := range genre.Aliases {
+		slugs = append(slugs, Slugify(alias))
+	}
+
+	query := `SELECT EXISTS (
+		SELECT 1 FROM genres g
+		WHERE g.id <> $1
+		AND (g.slug = ANY($2) OR genre_slug(g.name) = ANY($2)
+			OR EXISTS (SELECT 1 FROM unnest(g.aliases) a WHERE genre_slug(a) = ANY($2)))
+	)`
+
+	var exists bool
+	err := q.QueryRowContext(ctx, query, genre.ID, pq.Array(slugs)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicateGenre
+	}
+	return nil
+}
+
+func genreError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateGenre
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCState is a sign-in that was sent to the identity provider.
+type OIDCState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (m IdentityModel) InsertState(state *OIDCState) error {
+	query := `INSERT INTO oidc_states (state, nonce, code_verifier, expires_at)
+	VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, state.State, state.Nonce, state.CodeVerifier, state.Expiry)
+	return err
+}
+
+// ConsumeState removes and returns an unexpired sign-in state, so that each
+// state can only be used once.
+func (m IdentityModel) ConsumeState(state string) (*OIDCState, error) {
+	query := `DELETE FROM oidc_states
+	WHERE state = $1
+	RETURNING state, nonce, code_verifier, expires_at`
+
+	var s OIDCState
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, state).Scan(&s.State, &s.Nonce, &s.CodeVerifier, &s.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(s.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+	return &s, nil
+}
+
+// DeleteExpiredStates removes sign-ins that were never completed.
+func (m IdentityModel) DeleteExpiredStates() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetUser returns the user linked to the subject of an identity provider.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.email_undeliverable, users.locale, users.version
+		FROM users
+		INNER JOIN user_identities ON user_identities.user_id = users.id
+		WHERE user_identities.issuer = $1 AND user_identities.subject = $2
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.Created_at,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.EmailUndeliverable,
+		&user.Locale,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Link connects the subject of an identity provider to a user. A user that
+// is not activated yet is activated, since the provider has verified the
+// email address. Whoever registered the account may not own that address, so
+// its password, tokens and API keys are discarded at the same time and the
+// account can only be reached through the provider afterwards.
+func (m IdentityModel) Link(user *User, issuer, subject string) error {
+	query := `INSERT INTO user_identities (issuer, subject, user_id)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (issuer, subject) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, issuer, subject, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if user.Activated {
+			return nil
+		}
+
+		err = revokeAccess(ctx, tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		user.Activated = true
+		user.Password.hash = []byte{}
+		return updateUser(ctx, tx, user)
+	})
+}
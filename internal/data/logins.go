@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LockoutPolicy describes how failed logins are throttled. Every failure
+// locks the key for BaseDelay, doubling with each further failure, and
+// MaxFailures failures lock it for Lockout. Failures older than Window are
+// forgotten.
+type LockoutPolicy struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	Lockout     time.Duration
+	Window      time.Duration
+}
+
+// AccountLoginKey and IPLoginKey build the keys failed logins are counted
+// under.
+func AccountLoginKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// Locked reports whether any of the keys is currently locked.
+func (m LoginFailureModel) Locked(keys ...string) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM login_failures WHERE key = ANY($1) AND locked_until > NOW()
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var locked bool
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&locked)
+	return locked, err
+}
+
+// RecordFailure counts a failed login against key and locks it according to
+// policy. It returns true when this failure is the one that reached
+// MaxFailures.
+func (m LoginFailureModel) RecordFailure(key string, policy LockoutPolicy) (bool, error) {
+	query := `INSERT INTO login_failures (key, failures, last_failure_at)
+	VALUES ($1, 1, NOW())
+	ON CONFLICT (key) DO UPDATE
+	SET failures = CASE
+			WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+			ELSE login_failures.failures + 1
+		END,
+		last_failure_at = NOW()
+	RETURNING failures`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var failures int
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, key, policy.Window.Seconds()).Scan(&failures)
+		if err != nil {
+			return err
+		}
+
+		delay := policy.Lockout
+		if failures < policy.MaxFailures {
+			backoff := float64(policy.BaseDelay) * math.Pow(2, float64(failures-1))
+			delay = time.Duration(math.Min(backoff, float64(policy.Lockout)))
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE login_failures SET locked_until = NOW() + make_interval(secs => $1) WHERE key = $2`, delay.Seconds(), key)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return failures == policy.MaxFailures, nil
+}
+
+// Reset forgets the failed logins counted against key.
+func (m LoginFailureModel) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	return err
+}
+
+// DeleteExpired removes the counters that are neither locked nor recent
+// enough to count any more, and returns how many were removed.
+func (m LoginFailureModel) DeleteExpired(window time.Duration) (int64, error) {
+	query := `DELETE FROM login_failures
+	WHERE last_failure_at < NOW() - make_interval(secs => $1)
+	AND (locked_until IS NULL OR locked_until < NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -1,32 +1,192 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrBulkAborted    = errors.New("bulk operation aborted")
 )
 
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so single-row queries can be
+// shared between standalone calls and multi-statement transactions.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Models struct {
 	Movies interface {
-		Insert(*Movie) error
+		Insert(*Movie, *int64) error
 		Get(int64) (*Movie, error)
-		Update(*Movie) error
+		GetIncludingDeleted(int64) (*Movie, error)
+		Update(*Movie, *int64) error
+		Delete(int64, *int64) error
+		Restore(int64, *int64) (*Movie, error)
+		Purge(time.Duration) (int64, error)
+		GetAll(string, []string, int64, bool, Filters) ([]*Movie, Metadata, error)
+		InsertMany([]*Movie, bool, *int64) ([]error, error)
+		UpdateMany([]*Movie, bool, *int64) ([]error, error)
+		DeleteMany([]int64, bool, *int64) ([]error, error)
+		Export(string, []string, int64, bool, Filters, func(*Movie) error) error
+		Import(func(func(*Movie) error) error, bool, *int64) (int, error)
+		GetRevisions(int64, Filters) ([]*MovieRevision, Metadata, error)
+		GetRevision(int64, int32) (*MovieRevision, error)
+		Rollback(*Movie, *int64) error
+	}
+	Genres interface {
+		Insert(*Genre) error
+		Get(int64) (*Genre, error)
+		Update(*Genre, string, *int64) error
 		Delete(int64) error
-		GetAll(string, []string, Filters) ([]*Movie, Metadata, error)
+		GetAll(string, Filters) ([]*Genre, Metadata, error)
+		Catalog() (*GenreCatalog, error)
+	}
+	People interface {
+		Insert(*Person) error
+		Get(int64) (*Person, error)
+		Update(*Person) error
+		Delete(int64) error
+		GetAll(string, Filters) ([]*Person, Metadata, error)
+		GetCredits(int64) ([]*Credit, error)
+		GetCreditsForMovies([]int64) (map[int64][]*Credit, error)
+		SetCredits(int64, []*Credit) error
 	}
 	Users interface {
 		Insert(*User) error
+		Register(*User, time.Duration, string) (*Token, error)
+		Get(int64) (*User, error)
+		GetByEmail(string) (*User, error)
+		GetForToken(string, string) (*User, error)
 		Update(*User) error
+		ChangeEmail(*User, time.Duration, string) (*Token, error)
+		SetActivated(*User, *AuditEntry) error
+		RevokeAccess(int64, *AuditEntry) error
+		Anonymise(*User) error
+		GetAll(string, *bool, Filters) ([]*User, Metadata, error)
+	}
+	Tokens interface {
+		New(int64, time.Duration, string) (*Token, error)
+		NewSession(int64, time.Duration, string, string) (*Token, error)
+		Insert(*Token) error
+		DeleteAllForUser(string, int64) error
+		Touch(string) (int64, error)
+		GetSessions(int64, int64) ([]*Session, error)
+		DeleteSession(int64, int64) error
+		DeleteExpired() (int64, error)
+	}
+	Reviews interface {
+		Insert(*Review) error
+		GetForUser(int64, int64) (*Review, error)
+		Update(*Review) error
+		Delete(int64, int64) error
+		GetAllForMovie(int64, Filters) ([]*Review, Metadata, error)
+	}
+	Watchlists interface {
+		Add(int64, *Movie) (*WatchlistItem, error)
+		Remove(int64, int64) error
+		Move(int64, int64, int) (int, error)
+		GetAll(int64, Filters) ([]*WatchlistItem, Metadata, error)
+		MarkWatched(int64, *WatchEvent) error
+		DeleteEvent(int64, int64) error
+		GetHistory(int64, Filters) ([]*WatchEvent, Metadata, error)
+	}
+	Recommendations interface {
+		Similar(*Movie, Filters) ([]*ScoredMovie, Metadata, error)
+		ForUser(int64, Filters) ([]*ScoredMovie, Metadata, error)
+	}
+	Permissions interface {
+		GetAllForUser(int64) (Permissions, error)
+		AddForUser(int64, []string, *AuditEntry) error
+		RemoveForUser(int64, []string, *AuditEntry) error
+	}
+	Audit interface {
+		Insert(*AuditEntry) error
+	}
+	LoginFailures interface {
+		Locked(...string) (bool, error)
+		RecordFailure(string, LockoutPolicy) (bool, error)
+		Reset(string) error
+		DeleteExpired(time.Duration) (int64, error)
+	}
+	TOTP interface {
+		GetForUser(int64) (*TOTP, error)
+		Begin(*TOTP) error
+		Confirm(int64, int64) ([]string, error)
+		UseStep(int64, int64) (bool, error)
+		UseRecoveryCode(int64, string) (bool, error)
+		Delete(int64) error
+	}
+	APIKeys interface {
+		Insert(*APIKey) error
+		GetForPlaintext(string) (*APIKey, error)
+		GetAllForUser(int64) ([]*APIKey, error)
+		Delete(int64, int64) error
+	}
+	Identities interface {
+		InsertState(*OIDCState) error
+		ConsumeState(string) (*OIDCState, error)
+		DeleteExpiredStates() (int64, error)
+		GetUser(string, string) (*User, error)
+		Link(*User, string, string) error
+	}
+	Outbox interface {
+		Enqueue(string, string, string, map[string]interface{}) error
+		Claim(int, time.Duration) ([]*OutboxEmail, error)
+		MarkSent(int64) error
+		MarkFailed(int64, string, time.Time, bool) error
+		MarkSuppressed(int64) error
+	}
+	Suppressions interface {
+		Insert(*EmailSuppression) error
+		Suppressed(string) (bool, error)
+		GetAll(string, string, Filters) ([]*EmailSuppression, Metadata, error)
+		Delete(int64) error
 	}
 }
 
+// withTx runs fn inside a transaction that is committed if fn returns nil and
+// rolled back otherwise.
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func NewModels(db *sql.DB) Models {
+	recommendations := newRecommendationCache(10 * time.Minute)
+
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
+		Movies:          MovieModel{DB: db},
+		Genres:          GenreModel{DB: db},
+		People:          PersonModel{DB: db},
+		Users:           UserModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Reviews:         ReviewModel{DB: db, cache: recommendations},
+		Watchlists:      WatchlistModel{DB: db, cache: recommendations},
+		Recommendations: RecommendationModel{DB: db, cache: recommendations},
+		Permissions:     PermissionModel{DB: db},
+		Audit:           AuditModel{DB: db},
+		LoginFailures:   LoginFailureModel{DB: db},
+		TOTP:            TOTPModel{DB: db},
+		APIKeys:         APIKeyModel{DB: db},
+		Identities:      IdentityModel{DB: db},
+		Outbox:          OutboxModel{DB: db},
+		Suppressions:    SuppressionModel{DB: db},
 	}
 }
@@ -14,15 +14,24 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,string"`
-	Genres    []string  `json:"genres"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"-"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year,omitempty"`
+	Runtime   Runtime    `json:"runtime,string"`
+	Genres    []string   `json:"genres"`
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Credits   []*Credit  `json:"credits,omitempty"`
+
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int     `json:"rating_count"`
 }
 
+// ratingColumns selects the average rating and the number of ratings of a
+// movie from the movie_ratings summary, which must be LEFT JOINed as r.
+const ratingColumns = `COALESCE(round(r.rating_sum::numeric / NULLIF(r.rating_count, 0), 2), 0), COALESCE(r.rating_count, 0)`
+
 type Runtime int32
 
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
@@ -45,7 +54,9 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	return nil
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie checks a movie before it is saved. Genres must already have
+// been normalised with catalog.Normalize and each one must be in catalog.
+func ValidateMovie(v *validator.Validator, movie *Movie, catalog *GenreCatalog) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(movie.Year != 0, "year", "must be provided")
@@ -57,6 +68,10 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	for _, genre := range movie.Genres {
+		v.Check(catalog.Contains(genre), "genres", fmt.Sprintf("must only contain known genres, %q is not one", genre))
+	}
 }
 
 // ----Movie Operations Starts from here ----//
@@ -65,25 +80,49 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(movie *Movie, userID *int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return insertMovie(ctx, tx, movie, userID)
+	})
+}
+
+func insertMovie(ctx context.Context, q dbtx, movie *Movie, userID *int64) error {
 	query := `INSERT INTO movies(title,year,runtime,genres)
 			 VALUES($1, $2, $3, $4)
 			 RETURNING id, created_at, version`
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return insertRevision(ctx, q, movie, RevisionInsert, userID)
 }
 
+// Get returns the movie with the given id unless it has been soft deleted.
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.get(id, false)
+}
+
+// GetIncludingDeleted is like Get but also returns soft deleted movies.
+func (m MovieModel) GetIncludingDeleted(id int64) (*Movie, error) {
+	return m.get(id, true)
+}
+
+func (m MovieModel) get(id int64, includeDeleted bool) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, created_at, title, year, runtime, genres, version FROM movies WHERE id=$1`
+	query := `SELECT id, created_at, title, year, runtime, genres, version, deleted_at, ` + ratingColumns + `
+	FROM movies
+	LEFT JOIN movie_ratings r ON r.movie_id = movies.id
+	WHERE id = $1 AND (deleted_at IS NULL OR $2)`
 
 	var movie Movie
 
@@ -91,7 +130,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, includeDeleted).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -99,6 +138,9 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.DeletedAt,
+		&movie.AverageRating,
+		&movie.RatingCount,
 	)
 
 	if err != nil {
@@ -112,17 +154,23 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(movie *Movie, userID *int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return updateMovie(ctx, tx, movie, RevisionUpdate, userID)
+	})
+}
+
+func updateMovie(ctx context.Context, q dbtx, movie *Movie, changeType string, userID *int64) error {
 	query := `UPDATE movies
 	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 	RETURNING version`
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -131,51 +179,70 @@ func (m MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
-	return nil
+
+	return insertRevision(ctx, q, movie, changeType, userID)
 }
 
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(id int64, userID *int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return deleteMovie(ctx, tx, id, userID)
+	})
+}
+
+func deleteMovie(ctx context.Context, q dbtx, id int64, userID *int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM movies
-			  WHERE id = $1`
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	query := `UPDATE movies
+			  SET deleted_at = NOW(), version = version + 1
+			  WHERE id = $1 AND deleted_at IS NULL
+			  RETURNING id, created_at, title, year, runtime, genres, version, deleted_at`
 
-	result, err := m.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	var movie Movie
 
-	rowsAffected, err := result.RowsAffected()
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.DeletedAt,
+	)
 	if err != nil {
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	return nil
+	return insertRevision(ctx, q, &movie, RevisionDelete, userID)
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(title string, genres []string, personID int64, includeDeleted bool, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at, `+ratingColumns+`
 	FROM movies
+	LEFT JOIN movie_ratings r ON r.movie_id = movies.id
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 	AND (genres @> $2 OR $2 = '{}')
+	AND (deleted_at IS NULL OR $3)
+	AND ($4 = 0 OR EXISTS (SELECT 1 FROM movie_credits c WHERE c.movie_id = movies.id AND c.person_id = $4))
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4
+	LIMIT $5 OFFSET $6
 	`, filters.SortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []interface{}{title, pq.Array(genres), includeDeleted, personID, filters.limit(), filters.offset()}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -193,6 +260,9 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.DeletedAt,
+			&movie.AverageRating,
+			&movie.RatingCount,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -209,3 +279,309 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 
 }
+
+// Restore clears the deleted_at timestamp of a soft deleted movie and returns
+// the restored record.
+func (m MovieModel) Restore(id int64, userID *int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `WITH restored AS (
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, title, year, runtime, genres, version
+	)
+	SELECT id, created_at, title, year, runtime, genres, version, ` + ratingColumns + `
+	FROM restored
+	LEFT JOIN movie_ratings r ON r.movie_id = restored.id`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, id).Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, &movie, RevisionRestore, userID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// Purge permanently removes movies that were soft deleted more than retention
+// ago, together with their revision history, and returns how many rows were
+// removed.
+func (m MovieModel) Purge(retention time.Duration) (int64, error) {
+	query := `DELETE FROM movies
+	WHERE deleted_at < NOW() - make_interval(secs => $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// InsertMany, UpdateMany and DeleteMany apply a batch of changes and return one
+// error slot per item. In atomic mode every item runs inside a single
+// transaction and the first failing item rolls the whole batch back, which is
+// reported as ErrBulkAborted. Otherwise each item is applied on its own and
+// failures do not affect the rest of the batch.
+func (m MovieModel) InsertMany(movies []*Movie, atomic bool, userID *int64) ([]error, error) {
+	return m.bulk(len(movies), atomic, func(ctx context.Context, q dbtx, i int) error {
+		return insertMovie(ctx, q, movies[i], userID)
+	})
+}
+
+func (m MovieModel) UpdateMany(movies []*Movie, atomic bool, userID *int64) ([]error, error) {
+	return m.bulk(len(movies), atomic, func(ctx context.Context, q dbtx, i int) error {
+		return updateMovie(ctx, q, movies[i], RevisionUpdate, userID)
+	})
+}
+
+func (m MovieModel) DeleteMany(ids []int64, atomic bool, userID *int64) ([]error, error) {
+	return m.bulk(len(ids), atomic, func(ctx context.Context, q dbtx, i int) error {
+		return deleteMovie(ctx, q, ids[i], userID)
+	})
+}
+
+func (m MovieModel) bulk(n int, atomic bool, fn func(context.Context, dbtx, int) error) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	errs := make([]error, n)
+
+	if !atomic {
+		for i := 0; i < n; i++ {
+			errs[i] = withTx(ctx, m.DB, func(tx *sql.Tx) error {
+				return fn(ctx, tx, i)
+			})
+		}
+		return errs, nil
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < n; i++ {
+		err := fn(ctx, tx, i)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound), errors.Is(err, ErrEditConflict):
+				errs[i] = err
+				return errs, ErrBulkAborted
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	return errs, tx.Commit()
+}
+
+// Export streams every movie matching the same filters as GetAll to fn, in
+// sort order and without pagination. Iteration stops at the first error
+// returned by fn.
+func (m MovieModel) Export(title string, genres []string, personID int64, includeDeleted bool, filters Filters, fn func(*Movie) error) error {
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, year, runtime, genres, version, deleted_at
+	FROM movies
+	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (genres @> $2 OR $2 = '{}')
+	AND (deleted_at IS NULL OR $3)
+	AND ($4 = 0 OR EXISTS (SELECT 1 FROM movie_credits c WHERE c.movie_id = movies.id AND c.person_id = $4))
+	ORDER BY %s %s, id ASC
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), includeDeleted, personID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return err
+		}
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ImportRowError is returned by the add function of a best effort Import when
+// the database rejects a single movie. The movie is skipped and the import
+// can carry on.
+type ImportRowError struct {
+	Err error
+}
+
+func (e *ImportRowError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ImportRowError) Unwrap() error {
+	return e.Err
+}
+
+// Import passes fn a function that writes a movie, and returns the number of
+// movies written. The movies are committed only if fn returns nil. In atomic
+// mode the movies are streamed with COPY and any database error aborts the
+// import. Otherwise each movie is inserted under its own savepoint, so a
+// movie the database rejects is rolled back on its own and reported to fn as
+// an *ImportRowError.
+func (m MovieModel) Import(fn func(add func(*Movie) error) error, atomic bool, userID *int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if atomic {
+		count, err = copyMovies(ctx, tx, fn, userID)
+	} else {
+		count, err = insertMoviesEach(ctx, tx, fn, userID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func insertMoviesEach(ctx context.Context, tx *sql.Tx, fn func(add func(*Movie) error) error, userID *int64) (int, error) {
+	count := 0
+	err := fn(func(movie *Movie) error {
+		_, err := tx.ExecContext(ctx, `SAVEPOINT import_movie`)
+		if err != nil {
+			return err
+		}
+
+		err = insertMovie(ctx, tx, movie, userID)
+		if err != nil {
+			var pqErr *pq.Error
+			if !errors.As(err, &pqErr) {
+				return err
+			}
+			_, rollbackErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_movie`)
+			if rollbackErr != nil {
+				return rollbackErr
+			}
+			return &ImportRowError{Err: err}
+		}
+
+		_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT import_movie`)
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func copyMovies(ctx context.Context, tx *sql.Tx, fn func(add func(*Movie) error) error, userID *int64) (int, error) {
+	// COPY cannot return the generated ids, so remember where the sequence
+	// stood and record the initial revision of every newer row afterwards.
+	var lastID int64
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(max(id), 0) FROM movies`).Scan(&lastID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies", "title", "year", "runtime", "genres"))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	count := 0
+	err = fn(func(movie *Movie) error {
+		_, err := stmt.ExecContext(ctx, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO movie_revisions (movie_id, version, change_type, user_id, title, year, runtime, genres)
+	SELECT id, version, $2, $3::bigint, title, year, runtime, genres
+	FROM movies
+	WHERE id > $1
+	ON CONFLICT (movie_id, version) DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, lastID, RevisionInsert, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
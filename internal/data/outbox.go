@@ -0,0 +1,173 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutboxPending    = "pending"
+	OutboxSent       = "sent"
+	OutboxDead       = "dead"
+	OutboxSuppressed = "suppressed"
+)
+
+// OutboxEmail is an email waiting in the outbox to be sent. Emails are
+// written in the same transaction as the change that causes them, so they
+// are neither lost when the process dies nor sent for changes that were
+// rolled back.
+type OutboxEmail struct {
+	ID            int64
+	CreatedAt     time.Time
+	Recipient     string
+	Locale        string
+	Template      string
+	Data          map[string]interface{}
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+func enqueueEmail(ctx context.Context, q dbtx, recipient, locale, templateFile string, data map[string]interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO email_outbox (recipient, locale, template, data)
+	VALUES ($1, $2, $3, $4)`
+
+	_, err = q.ExecContext(ctx, query, recipient, locale, templateFile, js)
+	return err
+}
+
+// Enqueue adds an email to the outbox on its own.
+func (m OutboxModel) Enqueue(recipient, locale, templateFile string, data map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return enqueueEmail(ctx, m.DB, recipient, locale, templateFile, data)
+}
+
+// Claim takes up to limit pending emails that are due and counts an attempt
+// for each of them. Rows locked by other workers are skipped, and the claimed
+// ones are not due again until lease has passed, so an email whose worker
+// dies while sending it is retried later.
+func (m OutboxModel) Claim(limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	query := `
+	WITH due AS (
+		SELECT id
+		FROM email_outbox
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE email_outbox
+	SET attempts = attempts + 1, next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+	FROM due
+	WHERE email_outbox.id = due.id
+	RETURNING email_outbox.id, email_outbox.created_at, email_outbox.recipient, email_outbox.locale, email_outbox.template, email_outbox.data,
+		email_outbox.status, email_outbox.attempts, email_outbox.last_error, email_outbox.next_attempt_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+	for rows.Next() {
+		var email OutboxEmail
+		var js []byte
+		err := rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Locale,
+			&email.Template,
+			&js,
+			&email.Status,
+			&email.Attempts,
+			&email.LastError,
+			&email.NextAttemptAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Numbers are kept as json.Number so that IDs are rendered in
+		// templates exactly as they were stored.
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.UseNumber()
+		err = dec.Decode(&email.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that an email has been delivered.
+func (m OutboxModel) MarkSent(id int64) error {
+	query := `UPDATE email_outbox
+	SET status = 'sent', sent_at = NOW(), last_error = ''
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed records a failed attempt. The email is tried again at retryAt,
+// or moved to the dead letter state when dead is set.
+func (m OutboxModel) MarkFailed(id int64, lastError string, retryAt time.Time, dead bool) error {
+	status := OutboxPending
+	if dead {
+		status = OutboxDead
+	}
+
+	query := `UPDATE email_outbox
+	SET status = $1, last_error = $2, next_attempt_at = $3
+	WHERE id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, lastError, retryAt, id)
+	return err
+}
+
+// MarkSuppressed records that an email was not sent because its recipient is
+// on the suppression list.
+func (m OutboxModel) MarkSuppressed(id int64) error {
+	query := `UPDATE email_outbox
+	SET status = 'suppressed', last_error = ''
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,336 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+// PasswordChecker rejects new passwords that are unsuitable for a user by
+// adding an error on the "password" key.
+type PasswordChecker interface {
+	CheckPassword(v *validator.Validator, password string, user *User)
+}
+
+// PasswordPolicy runs every checker in turn. The first rejection is the one
+// reported, so checking stops there. A password that has already been
+// rejected, for example by ValidatePasswordPlainText for its length, is not
+// checked at all.
+type PasswordPolicy []PasswordChecker
+
+func (p PasswordPolicy) Check(v *validator.Validator, password string, user *User) {
+	for _, checker := range p {
+		if _, rejected := v.Errors["password"]; rejected {
+			return
+		}
+		checker.CheckPassword(v, password, user)
+	}
+}
+
+// PersonalInfoChecker rejects passwords that contain the user's name or
+// email address, ignoring case and common character substitutions.
+type PersonalInfoChecker struct{}
+
+func (PersonalInfoChecker) CheckPassword(v *validator.Validator, password string, user *User) {
+	lowered := strings.ToLower(password)
+	unleeted := unleet(lowered)
+
+	terms := []string{strings.ToLower(user.Email)}
+	local, _, _ := strings.Cut(strings.ToLower(user.Email), "@")
+	terms = append(terms, local)
+	terms = append(terms, strings.FieldsFunc(local, isSeparator)...)
+	terms = append(terms, strings.FieldsFunc(strings.ToLower(user.Name), isSeparator)...)
+
+	for _, term := range terms {
+		if len([]rune(term)) < 3 {
+			continue
+		}
+		if strings.Contains(lowered, term) || strings.Contains(unleeted, term) {
+			v.AddError("password", "must not contain your name or email address")
+			return
+		}
+	}
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// StrengthChecker rejects passwords whose estimated strength is below
+// MinScore, on the 0 to 4 scale of PasswordStrength.
+type StrengthChecker struct {
+	MinScore int
+}
+
+func (c StrengthChecker) CheckPassword(v *validator.Validator, password string, user *User) {
+	v.Check(PasswordStrength(password) >= c.MinScore, "password", "is too easy to guess, try a longer password or one made of several unrelated words")
+}
+
+// commonPasswords are words that guessing attacks try first. A password made
+// of them is only as strong as the number of words in the list.
+var commonPasswords = []string{
+	"password", "passwd", "qwerty", "letmein", "welcome", "admin", "login",
+	"monkey", "dragon", "master", "shadow", "sunshine", "princess", "football",
+	"baseball", "soccer", "hockey", "batman", "superman", "iloveyou", "trustno1",
+	"freedom", "whatever", "starwars", "secret", "summer", "winter", "spring",
+	"autumn", "flower", "hello", "charlie", "michael", "jordan", "jennifer",
+	"hunter", "killer", "pepper", "cheese", "computer", "internet", "access",
+	"love", "angel", "lovely", "forever", "change", "default", "guest", "test",
+	"user", "root", "pass", "abc", "movie", "movies", "cinema", "film", "greenlight",
+}
+
+// keyboardRows are walked by people who think a pattern on the keyboard is
+// random.
+var keyboardRows = []string{
+	"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890", "qazwsxedc",
+}
+
+// longestCommonPassword and longestKeyboardRow bound how far a pattern can
+// reach from one position, which keeps PasswordEntropy linear in the length
+// of the password.
+var (
+	longestCommonPassword = longest(commonPasswords)
+	longestKeyboardRow    = longest(keyboardRows)
+)
+
+func longest(words []string) int {
+	n := 0
+	for _, word := range words {
+		n = max(n, len([]rune(word)))
+	}
+	return n
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '3': 'e', '1': 'i', '!': 'i', '0': 'o',
+	'$': 's', '5': 's', '7': 't', '+': 't',
+}
+
+func unleet(s string) string {
+	return strings.Map(func(r rune) rune {
+		if sub, ok := leetSubstitutions[r]; ok {
+			return sub
+		}
+		return r
+	}, s)
+}
+
+// PasswordStrength estimates how many guesses an attacker needs for the
+// password and maps that to a score from 0 (trivial) to 4 (strong), with the
+// same thresholds as zxcvbn: fewer than 10^3, 10^6, 10^8 and 10^10 guesses.
+//
+// The password is split greedily into the longest patterns found at each
+// position: common words (also in leetspeak), runs of a repeated character,
+// alphabetical or numerical sequences and keyboard walks. Each pattern costs
+// roughly the log of the number of patterns of its kind, and every remaining
+// character costs the log of the size of the character classes used.
+func PasswordStrength(password string) int {
+	bits := PasswordEntropy(password)
+
+	switch {
+	case bits < math.Log2(1e3):
+		return 0
+	case bits < math.Log2(1e6):
+		return 1
+	case bits < math.Log2(1e8):
+		return 2
+	case bits < math.Log2(1e10):
+		return 3
+	default:
+		return 4
+	}
+}
+
+// PasswordEntropy returns the estimated number of guesses for the password
+// as a power of two. See PasswordStrength.
+func PasswordEntropy(password string) float64 {
+	lowered := []rune(strings.ToLower(password))
+	unleeted := []rune(unleet(string(lowered)))
+	charBits := math.Log2(float64(cardinality(password)))
+	wordBits := math.Log2(float64(len(commonPasswords))) + 1
+
+	bits := 0.0
+	for i := 0; i < len(lowered); {
+		length, cost := 1, charBits
+
+		window := string(unleeted[i:min(len(unleeted), i+longestCommonPassword)])
+		for _, word := range commonPasswords {
+			n := len([]rune(word))
+			if n > length && strings.HasPrefix(window, word) {
+				length, cost = n, wordBits
+			}
+		}
+
+		if n := repeatLength(lowered[i:]); n >= 3 && n > length {
+			length, cost = n, charBits+math.Log2(float64(n))
+		}
+
+		if n := sequenceLength(lowered[i:]); n >= 3 && n > length {
+			length, cost = n, charBits+math.Log2(float64(n))+1
+		}
+
+		if n := keyboardWalkLength(lowered[i:]); n >= 3 && n > length {
+			length, cost = n, math.Log2(float64(len(keyboardRows)*10))+math.Log2(float64(n))+1
+		}
+
+		bits += cost
+		i += length
+	}
+
+	return bits
+}
+
+func cardinality(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	return max(n, 2)
+}
+
+func repeatLength(s []rune) int {
+	n := 1
+	for n < len(s) && s[n] == s[0] {
+		n++
+	}
+	return n
+}
+
+func sequenceLength(s []rune) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+
+	delta := s[1] - s[0]
+	if delta != 1 && delta != -1 {
+		return 1
+	}
+
+	n := 2
+	for n < len(s) && s[n]-s[n-1] == delta {
+		n++
+	}
+	return n
+}
+
+func keyboardWalkLength(s []rune) int {
+	longest := 1
+	for _, row := range keyboardRows {
+		for _, line := range []string{row, reverse(row)} {
+			for n := min(len(s), longestKeyboardRow); n > longest; n-- {
+				if strings.Contains(line, string(s[:n])) {
+					longest = n
+					break
+				}
+			}
+		}
+	}
+	return longest
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// BreachedPasswords is a set of SHA-1 password hashes known from data
+// breaches. Like the k-anonymity range API of Have I Been Pwned, the hashes
+// are grouped by their first five hex digits, so a lookup only ever touches
+// the suffixes sharing a prefix.
+type BreachedPasswords struct {
+	ranges map[string][]string
+}
+
+// LoadBreachedPasswords reads a breached hash list from a file with one
+// upper or lower case hex SHA-1 hash per line, optionally followed by a colon
+// and a count as in the Have I Been Pwned downloads. Blank lines and lines
+// starting with # are ignored.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedPasswords{ranges: make(map[string][]string)}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-1 hash %q", path, line, hash)
+		}
+
+		b.ranges[hash[:5]] = append(b.ranges[hash[:5]], hash[5:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range b.ranges {
+		sort.Strings(suffixes)
+	}
+
+	return b, nil
+}
+
+// Contains reports whether the password appears in the list.
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := b.ranges[hash[:5]]
+	i := sort.SearchStrings(suffixes, hash[5:])
+	return i < len(suffixes) && suffixes[i] == hash[5:]
+}
+
+func (b *BreachedPasswords) CheckPassword(v *validator.Validator, password string, user *User) {
+	v.Check(!b.Contains(password), "password", "has appeared in a data breach and must not be used")
+}
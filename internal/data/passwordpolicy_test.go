@@ -0,0 +1,167 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+	}{
+		{"password", 0},
+		{"p@ssw0rd", 0},
+		{"aaaaaaaaaaaa", 0},
+		{"qwertyuiop", 1},
+		{"monkey123", 1},
+		{"abcdefgh12345678", 1},
+		{"zxcvbnmasdfghjkl", 1},
+		{"xkq7#Vm2!pLz", 4},
+		{"correct horse battery staple", 4},
+	}
+
+	for _, tt := range tests {
+		got := PasswordStrength(tt.password)
+		if got != tt.score {
+			t.Errorf("PasswordStrength(%q) = %d, want %d", tt.password, got, tt.score)
+		}
+	}
+}
+
+// Every pattern search is bounded, so the estimate stays linear in the
+// length of the password instead of trying every prefix at every position.
+func TestPasswordEntropyLongInput(t *testing.T) {
+	passwords := []string{
+		strings.Repeat("qwertyuiop", 1000),
+		strings.Repeat("a1b2", 2500),
+		strings.Repeat("x", 10000),
+	}
+
+	start := time.Now()
+	for _, password := range passwords {
+		PasswordEntropy(password)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("PasswordEntropy took %s for 10000 byte passwords", elapsed)
+	}
+}
+
+func TestPersonalInfoChecker(t *testing.T) {
+	user := &User{Name: "Alice Liddell", Email: "alice.wonder@example.com"}
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"liddell-rabbit-hole", false},
+		{"MyNameIsALICE!", false},
+		{"w0nd3rland-tea", false},
+		{"alice.wonder@example.com", false},
+		{"mad hatter tea party", true},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		PersonalInfoChecker{}.CheckPassword(v, tt.password, user)
+		if v.Valid() != tt.valid {
+			t.Errorf("PersonalInfoChecker(%q) valid = %t, want %t", tt.password, v.Valid(), tt.valid)
+		}
+	}
+}
+
+type countingChecker struct {
+	calls int
+}
+
+func (c *countingChecker) CheckPassword(v *validator.Validator, password string, user *User) {
+	c.calls++
+}
+
+func TestPasswordPolicyStopsAtFirstRejection(t *testing.T) {
+	user := &User{Name: "Alice", Email: "alice@example.com"}
+	counter := &countingChecker{}
+	policy := PasswordPolicy{StrengthChecker{MinScore: 3}, counter}
+
+	v := validator.New()
+	policy.Check(v, "password", user)
+	if v.Valid() {
+		t.Fatal("weak password was accepted")
+	}
+	if counter.calls != 0 {
+		t.Errorf("checker after the rejection ran %d times", counter.calls)
+	}
+
+	v = validator.New()
+	ValidatePasswordPlainText(v, strings.Repeat("x", maxPasswordLength+1))
+	policy.Check(v, strings.Repeat("x", maxPasswordLength+1), user)
+	if counter.calls != 0 {
+		t.Errorf("policy ran on a password that failed the length check")
+	}
+
+	v = validator.New()
+	policy.Check(v, "correct horse battery staple", user)
+	if !v.Valid() {
+		t.Errorf("strong password was rejected: %v", v.Errors)
+	}
+	if counter.calls != 1 {
+		t.Errorf("last checker ran %d times, want 1", counter.calls)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" in upper case with a count, and of "letmein" in
+	// lower case without one.
+	list := strings.Join([]string{
+		"# breached hashes",
+		"",
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493",
+		"b7a875fc1ea228b9061041b7cec4bd3c52ab3ce3",
+	}, "\n")
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte(list), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for password, want := range map[string]bool{
+		"password":   true,
+		"letmein":    true,
+		"Password":   false,
+		"unbreached": false,
+	} {
+		if got := breached.Contains(password); got != want {
+			t.Errorf("Contains(%q) = %t, want %t", password, got, want)
+		}
+	}
+
+	v := validator.New()
+	breached.CheckPassword(v, "letmein", &User{})
+	if v.Valid() {
+		t.Error("breached password was accepted")
+	}
+}
+
+func TestLoadBreachedPasswordsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte("not-a-hash\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadBreachedPasswords(path)
+	if err == nil {
+		t.Fatal("invalid hash list was loaded")
+	}
+}
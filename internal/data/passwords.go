@@ -0,0 +1,174 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidPasswordHash = errors.New("invalid password hash")
+)
+
+// maxPasswordLength bounds the work a single password check can cause. It
+// is well above bcrypt's 72 byte limit, which only matters for accounts whose
+// password has not been rehashed with argon2id yet.
+const maxPasswordLength = 1024
+
+// argon2idParams are the cost parameters of an argon2id hash.
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	saltLen uint32
+	keyLen  uint32
+}
+
+// currentArgon2idParams are used for every new hash. Hashes made with other
+// parameters, or with bcrypt, are replaced on the next successful login.
+var currentArgon2idParams = argon2idParams{
+	memory:  64 * 1024,
+	time:    3,
+	threads: 2,
+	saltLen: 16,
+	keyLen:  32,
+}
+
+// password holds a hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>. Hashes created before argon2id
+// was introduced are plain bcrypt hashes ($2a$...), which are still accepted.
+type password struct {
+	plainText *string
+	hash      []byte
+}
+
+func (p *password) Set(plainTextPassword string) error {
+	hash, err := hashArgon2id(plainTextPassword, currentArgon2idParams)
+	if err != nil {
+		return err
+	}
+
+	p.plainText = &plainTextPassword
+	p.hash = hash
+	return nil
+}
+
+func (p *password) Matches(plainTextPassword string) (bool, error) {
+	// Accounts activated through an identity provider have no password.
+	if len(p.hash) == 0 {
+		return false, nil
+	}
+
+	if isBcryptHash(p.hash) {
+		err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainTextPassword))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword), errors.Is(err, bcrypt.ErrPasswordTooLong):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2id(p.hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plainTextPassword), salt, params.time, params.memory, params.threads, params.keyLen)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was made with bcrypt or with argon2id
+// parameters other than the current ones.
+func (p *password) NeedsRehash() bool {
+	if isBcryptHash(p.hash) {
+		return true
+	}
+
+	params, _, _, err := decodeArgon2id(p.hash)
+	return err != nil || params != currentArgon2idParams
+}
+
+func isBcryptHash(hash []byte) bool {
+	return len(hash) > 3 && hash[0] == '$' && hash[1] == '2' && hash[3] == '$'
+}
+
+func hashArgon2id(plainTextPassword string, params argon2idParams) ([]byte, error) {
+	salt := make([]byte, params.saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plainTextPassword), salt, params.time, params.memory, params.threads, params.keyLen)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.memory,
+		params.time,
+		params.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return []byte(encoded), nil
+}
+
+func decodeArgon2id(hash []byte) (argon2idParams, []byte, []byte, error) {
+	var params argon2idParams
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	params.saltLen = uint32(len(salt))
+	params.keyLen = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+var (
+	dummyPassword     password
+	dummyPasswordOnce sync.Once
+)
+
+// SimulatePasswordCheck takes as long as checking a real password does, so
+// that logging in with an unknown email address cannot be told apart from
+// logging in with a wrong password by timing the response.
+func SimulatePasswordCheck(plainTextPassword string) {
+	dummyPasswordOnce.Do(func() {
+		_ = dummyPassword.Set("greenlight-dummy-password")
+	})
+	_, _ = dummyPassword.Matches(plainTextPassword)
+}
@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+var (
+	ErrPersonInUse   = errors.New("person in use")
+	ErrUnknownPerson = errors.New("unknown person")
+)
+
+var CreditRoles = []string{"director", "writer", "actor"}
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie in a given role. BillingOrder sorts the
+// credits of a movie, lowest first.
+type Credit struct {
+	MovieID      int64  `json:"-"`
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billing_order"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(person.BirthYear == 0 || person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+	v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	v.Check(len(person.Biography) <= 10000, "biography", "must not be more than 10000 bytes long")
+}
+
+func ValidateCredits(v *validator.Validator, credits []*Credit) {
+	v.Check(len(credits) <= 500, "credits", "must not contain more than 500 credits")
+
+	keys := []string{}
+	for _, credit := range credits {
+		v.Check(credit.PersonID > 0, "credits", "must only reference positive person ids")
+		v.Check(validator.In(credit.Role, CreditRoles...), "credits", "role must be one of director, writer or actor")
+		v.Check(credit.Character == "" || credit.Role == "actor", "credits", "character can only be set for actors")
+		v.Check(len(credit.Character) <= 500, "credits", "character must not be more than 500 bytes long")
+		v.Check(credit.BillingOrder > 0, "credits", "billing_order must be greater than zero")
+		keys = append(keys, fmt.Sprintf("%d:%s", credit.PersonID, credit.Role))
+	}
+	v.Check(validator.Unique(keys), "credits", "must not credit the same person twice in the same role")
+}
+
+type PersonModel struct {
+	DB *sql.DB
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `INSERT INTO people (name, birth_year, biography)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version`
+
+	args := []interface{}{person.Name, nullInt32(person.BirthYear), person.Biography}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, COALESCE(birth_year, 0), biography, version
+	FROM people
+	WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &person, nil
+}
+
+func (m PersonModel) Update(person *Person) error {
+	query := `UPDATE people
+	SET name = $1, birth_year = $2, biography = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+
+	args := []interface{}{person.Name, nullInt32(person.BirthYear), person.Biography, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a person. People who are still credited on a movie cannot
+// be deleted.
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM people WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrPersonInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, COALESCE(birth_year, 0), biography, version
+	FROM people
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+	for rows.Next() {
+		var person Person
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// GetCredits returns the credits of a single movie in billing order.
+func (m PersonModel) GetCredits(movieID int64) ([]*Credit, error) {
+	credits, err := m.GetCreditsForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	if credits[movieID] == nil {
+		return []*Credit{}, nil
+	}
+	return credits[movieID], nil
+}
+
+// GetCreditsForMovies returns the credits of several movies at once, keyed by
+// movie id.
+func (m PersonModel) GetCreditsForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+	query := `SELECT c.movie_id, c.person_id, p.name, c.role, c.character, c.billing_order
+	FROM movie_credits c
+	JOIN people p ON p.id = c.person_id
+	WHERE c.movie_id = ANY($1)
+	ORDER BY c.movie_id, c.billing_order, c.person_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit)
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credits[credit.MovieID] = append(credits[credit.MovieID], &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// SetCredits replaces all the credits of a movie.
+func (m PersonModel) SetCredits(movieID int64, credits []*Credit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1`, movieID)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING (SELECT name FROM people WHERE id = $2)`
+
+		for _, credit := range credits {
+			credit.MovieID = movieID
+			args := []interface{}{movieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+			err := tx.QueryRowContext(ctx, query, args...).Scan(&credit.Name)
+			if err != nil {
+				var pqErr *pq.Error
+				if errors.As(err, &pqErr) && pqErr.Constraint == "movie_credits_person_id_fkey" {
+					return ErrUnknownPerson
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func nullInt32(i int32) sql.NullInt32 {
+	return sql.NullInt32{Int32: i, Valid: i != 0}
+}
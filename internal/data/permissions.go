@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+const (
+	PermissionUsersAdmin  = "users:admin"
+	PermissionJobsAdmin   = "jobs:admin"
+	PermissionEmailsAdmin = "emails:admin"
+	PermissionMoviesAdmin = "movies:admin"
+	PermissionGenresAdmin = "genres:admin"
+	PermissionPeopleAdmin = "people:admin"
+)
+
+// Permissions holds the permission codes of a user, such as "users:admin".
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// AddForUser grants the permissions to the user and records entry in the
+// audit log. Permissions the user already has are left alone, and
+// ErrUnknownPermission is returned when a code does not exist.
+func (m PermissionModel) AddForUser(userID int64, codes []string, entry *AuditEntry) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var known int
+		err := tx.QueryRowContext(ctx, `SELECT count(*) FROM permissions WHERE code = ANY($1)`, pq.Array(codes)).Scan(&known)
+		if err != nil {
+			return err
+		}
+		if known != len(codes) {
+			return ErrUnknownPermission
+		}
+
+		_, err = tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		if err != nil {
+			return err
+		}
+
+		return insertAudit(ctx, tx, entry)
+	})
+}
+
+// RemoveForUser revokes the permissions of the user and records entry in the
+// audit log.
+func (m PermissionModel) RemoveForUser(userID int64, codes []string, entry *AuditEntry) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1
+		AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		if err != nil {
+			return err
+		}
+
+		return insertAudit(ctx, tx, entry)
+	})
+}
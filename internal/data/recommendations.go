@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxRecommendations caps how many ranked movies are computed and cached for
+// a single similar movies or recommendations list.
+const maxRecommendations = 500
+
+// ScoredMovie is a movie ranked by a recommendation query.
+type ScoredMovie struct {
+	Movie *Movie  `json:"movie"`
+	Score float64 `json:"score"`
+}
+
+type RecommendationModel struct {
+	DB    *sql.DB
+	cache *recommendationCache
+}
+
+// Similar ranks the movies that share at least one genre with movie by the
+// Jaccard similarity of their genres, breaking ties by how close their years
+// are. The ranking is cached per movie version.
+func (m RecommendationModel) Similar(movie *Movie, filters Filters) ([]*ScoredMovie, Metadata, error) {
+	key := fmt.Sprintf("similar:%d:%d", movie.ID, movie.Version)
+
+	query := `SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version, ` + ratingColumns + `,
+		cardinality(ARRAY(SELECT unnest(m.genres) INTERSECT SELECT unnest($2::text[])))::float8
+		/ cardinality(ARRAY(SELECT unnest(m.genres) UNION SELECT unnest($2::text[])))::float8 AS similarity
+	FROM movies m
+	LEFT JOIN movie_ratings r ON r.movie_id = m.id
+	WHERE m.id <> $1 AND m.deleted_at IS NULL AND m.genres && $2
+	ORDER BY similarity DESC, abs(m.year - $3) ASC, m.id ASC
+	LIMIT $4`
+
+	args := []interface{}{movie.ID, pq.Array(movie.Genres), movie.Year, maxRecommendations}
+	return m.ranked(key, query, args, filters)
+}
+
+// ForUser recommends movies to a user with item-based collaborative
+// filtering: every movie the user has not rated is scored by the user's own
+// scores, weighted by the cosine similarity between that movie's ratings and
+// the ratings of the movies the user scored. Movies the user has already
+// watched are left out. The ranking is cached until a rating or the user's
+// watch history changes.
+func (m RecommendationModel) ForUser(userID int64, filters Filters) ([]*ScoredMovie, Metadata, error) {
+	key := userRecommendationKey(userID)
+
+	query := `WITH rated AS (
+		SELECT movie_id, score FROM reviews WHERE user_id = $1
+	), norms AS (
+		SELECT movie_id, sqrt(sum(score * score))::float8 AS norm
+		FROM reviews
+		GROUP BY movie_id
+	), similarities AS (
+		SELECT a.movie_id AS rated_id, b.movie_id AS candidate_id,
+			sum(a.score * b.score)::float8 / (na.norm * nb.norm) AS similarity
+		FROM reviews a
+		INNER JOIN reviews b ON b.user_id = a.user_id AND b.movie_id <> a.movie_id
+		INNER JOIN norms na ON na.movie_id = a.movie_id
+		INNER JOIN norms nb ON nb.movie_id = b.movie_id
+		WHERE a.movie_id IN (SELECT movie_id FROM rated)
+		AND b.movie_id NOT IN (SELECT movie_id FROM rated)
+		GROUP BY a.movie_id, b.movie_id, na.norm, nb.norm
+	), predictions AS (
+		SELECT s.candidate_id, sum(s.similarity * rt.score) / sum(s.similarity) AS score, sum(s.similarity) AS support
+		FROM similarities s
+		INNER JOIN rated rt ON rt.movie_id = s.rated_id
+		GROUP BY s.candidate_id
+	)
+	SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version, ` + ratingColumns + `, round(p.score::numeric, 2)::float8
+	FROM predictions p
+	INNER JOIN movies m ON m.id = p.candidate_id
+	LEFT JOIN movie_ratings r ON r.movie_id = m.id
+	WHERE m.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM watch_events e WHERE e.user_id = $1 AND e.movie_id = m.id)
+	ORDER BY p.score DESC, p.support DESC, m.id ASC
+	LIMIT $2`
+
+	args := []interface{}{userID, maxRecommendations}
+	return m.ranked(key, query, args, filters)
+}
+
+// ranked returns a page of the ranking cached under key, running query to
+// compute the ranking when it is not cached.
+func (m RecommendationModel) ranked(key, query string, args []interface{}, filters Filters) ([]*ScoredMovie, Metadata, error) {
+	movies, generation, ok := m.cache.get(key)
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		rows, err := m.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		defer rows.Close()
+
+		movies = []*ScoredMovie{}
+		for rows.Next() {
+			scored := ScoredMovie{Movie: &Movie{}}
+			err := rows.Scan(
+				&scored.Movie.ID,
+				&scored.Movie.CreatedAt,
+				&scored.Movie.Title,
+				&scored.Movie.Year,
+				&scored.Movie.Runtime,
+				pq.Array(&scored.Movie.Genres),
+				&scored.Movie.Version,
+				&scored.Movie.AverageRating,
+				&scored.Movie.RatingCount,
+				&scored.Score,
+			)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			movies = append(movies, &scored)
+		}
+
+		if err = rows.Err(); err != nil {
+			return nil, Metadata{}, err
+		}
+
+		m.cache.put(key, generation, movies)
+	}
+
+	start := min(filters.offset(), len(movies))
+	end := min(start+filters.limit(), len(movies))
+
+	metadata := calculateMetadata(len(movies), filters.Page, filters.PageSize)
+
+	return movies[start:end], metadata, nil
+}
+
+type recommendationCacheEntry struct {
+	movies  []*ScoredMovie
+	expires time.Time
+}
+
+// recommendationCache keeps computed rankings for a while. Every
+// invalidation bumps the generation so that a ranking computed from data
+// that changed in the meantime is not stored.
+type recommendationCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	generation int
+	entries    map[string]recommendationCacheEntry
+}
+
+func newRecommendationCache(ttl time.Duration) *recommendationCache {
+	return &recommendationCache{
+		ttl:     ttl,
+		entries: make(map[string]recommendationCacheEntry),
+	}
+}
+
+func (c *recommendationCache) get(key string) ([]*ScoredMovie, int, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, c.generation, false
+	}
+	return entry.movies, c.generation, true
+}
+
+func (c *recommendationCache) put(key string, generation int, movies []*ScoredMovie) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = recommendationCacheEntry{movies: movies, expires: now.Add(c.ttl)}
+}
+
+// invalidate drops every cached ranking whose key starts with prefix.
+func (c *recommendationCache) invalidate(prefix string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// invalidateKey drops the cached ranking stored under exactly key.
+func (c *recommendationCache) invalidateKey(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.entries, key)
+}
+
+func userRecommendationKey(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Score     int       `json:"score"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score >= 1 && review.Score <= 10, "score", "must be between 1 and 10")
+	v.Check(len(review.Body) <= 10000, "body", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB    *sql.DB
+	cache *recommendationCache
+}
+
+// Insert adds a review and counts its score in the movie's rating summary.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `INSERT INTO reviews (user_id, movie_id, score, body)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		args := []interface{}{review.UserID, review.MovieID, review.Score, review.Body}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_user_id_movie_id_key"`:
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+
+		return adjustRating(ctx, tx, review.MovieID, review.Score, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate("user:")
+	return nil
+}
+
+// GetForUser returns the review a user wrote for a movie.
+func (m ReviewModel) GetForUser(movieID, userID int64) (*Review, error) {
+	query := `SELECT r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+	FROM reviews r
+	INNER JOIN users u ON u.id = r.user_id
+	WHERE r.movie_id = $1 AND r.user_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Score,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+// Update saves a changed review and moves the rating summary by the
+// difference between the old and the new score.
+func (m ReviewModel) Update(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var oldScore int
+		err := tx.QueryRowContext(ctx, `SELECT score FROM reviews WHERE id = $1 AND version = $2 FOR UPDATE`, review.ID, review.Version).Scan(&oldScore)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		query := `UPDATE reviews
+		SET score = $1, body = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3
+		RETURNING updated_at, version`
+
+		err = tx.QueryRowContext(ctx, query, review.Score, review.Body, review.ID).Scan(&review.UpdatedAt, &review.Version)
+		if err != nil {
+			return err
+		}
+
+		return adjustRating(ctx, tx, review.MovieID, review.Score-oldScore, 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate("user:")
+	return nil
+}
+
+// Delete removes the review a user wrote for a movie and takes its score out
+// of the rating summary.
+func (m ReviewModel) Delete(movieID, userID int64) error {
+	query := `DELETE FROM reviews
+	WHERE movie_id = $1 AND user_id = $2
+	RETURNING score`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var score int
+		err := tx.QueryRowContext(ctx, query, movieID, userID).Scan(&score)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		return adjustRating(ctx, tx, movieID, -score, -1)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate("user:")
+	return nil
+}
+
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+	FROM reviews r
+	INNER JOIN users u ON u.id = r.user_id
+	WHERE r.movie_id = $1
+	ORDER BY r.%s %s, r.id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+func adjustRating(ctx context.Context, q dbtx, movieID int64, sumDelta, countDelta int) error {
+	query := `INSERT INTO movie_ratings (movie_id, rating_sum, rating_count)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (movie_id) DO UPDATE
+	SET rating_sum = movie_ratings.rating_sum + EXCLUDED.rating_sum,
+		rating_count = movie_ratings.rating_count + EXCLUDED.rating_count`
+
+	_, err := q.ExecContext(ctx, query, movieID, sumDelta, countDelta)
+	return err
+}
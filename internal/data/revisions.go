@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RevisionInsert   = "insert"
+	RevisionUpdate   = "update"
+	RevisionDelete   = "delete"
+	RevisionRestore  = "restore"
+	RevisionRollback = "rollback"
+)
+
+// MovieRevision is a snapshot of a movie as it was at a given version. One is
+// written in the same transaction as every change, so the complete state of
+// any earlier version can be recovered from the revision with that version.
+type MovieRevision struct {
+	MovieID    int64      `json:"movie_id"`
+	Version    int32      `json:"version"`
+	ChangeType string     `json:"change_type"`
+	UserID     *int64     `json:"user_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Title      string     `json:"title"`
+	Year       int32      `json:"year,omitempty"`
+	Runtime    Runtime    `json:"runtime,string"`
+	Genres     []string   `json:"genres"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+// insertRevision records the current state of movie as a revision made by
+// userID, which is nil when the change was made anonymously.
+func insertRevision(ctx context.Context, q dbtx, movie *Movie, changeType string, userID *int64) error {
+	query := `INSERT INTO movie_revisions (movie_id, version, change_type, user_id, title, year, runtime, genres, deleted_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	args := []interface{}{
+		movie.ID,
+		movie.Version,
+		changeType,
+		userID,
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.DeletedAt,
+	}
+
+	_, err := q.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetRevisions returns a page of the revision history of a movie.
+func (m MovieModel) GetRevisions(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), movie_id, version, change_type, user_id, created_at, title, year, runtime, genres, deleted_at
+	FROM movie_revisions
+	WHERE movie_id = $1
+	ORDER BY %s %s
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var revision MovieRevision
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.ChangeType,
+			&revision.UserID,
+			&revision.CreatedAt,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// GetRevision returns the snapshot of a movie at the given version.
+func (m MovieModel) GetRevision(movieID int64, version int32) (*MovieRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return getRevision(ctx, m.DB, movieID, version)
+}
+
+func getRevision(ctx context.Context, q dbtx, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT movie_id, version, change_type, user_id, created_at, title, year, runtime, genres, deleted_at
+	FROM movie_revisions
+	WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	err := q.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.ChangeType,
+		&revision.UserID,
+		&revision.CreatedAt,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.DeletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &revision, nil
+}
+
+// Rollback saves movie, after the snapshot of an earlier revision has been
+// copied onto it with ApplyTo, as a new version recorded as a rollback.
+// movie.Version must still be the current version.
+func (m MovieModel) Rollback(movie *Movie, userID *int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return updateMovie(ctx, tx, movie, RevisionRollback, userID)
+	})
+}
+
+// ApplyTo copies the snapshot held by the revision onto movie. The genres are
+// copied as they were stored, so they have to be normalised and validated
+// against the current catalogue before the movie is saved.
+func (r *MovieRevision) ApplyTo(movie *Movie) {
+	movie.Title = r.Title
+	movie.Year = r.Year
+	movie.Runtime = r.Runtime
+	movie.Genres = r.Genres
+}
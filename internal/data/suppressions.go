@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+const (
+	SuppressionBounce    = "bounce"
+	SuppressionComplaint = "complaint"
+)
+
+// EmailSuppression is an address that no email is sent to any more, because
+// mail to it bounced permanently or its owner reported an email as spam.
+type EmailSuppression struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Email     string    `json:"email"`
+	Reason    string    `json:"reason"`
+	MessageID string    `json:"message_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+func ValidateEmailSuppression(v *validator.Validator, s *EmailSuppression) {
+	ValidateEmail(v, s.Email)
+	v.Check(validator.In(s.Reason, SuppressionBounce, SuppressionComplaint), "reason", "must be bounce or complaint")
+	v.Check(len(s.MessageID) <= 500, "message_id", "must not be more than 500 bytes long")
+	v.Check(len(s.Detail) <= 1000, "detail", "must not be more than 1000 bytes long")
+}
+
+type SuppressionModel struct {
+	DB *sql.DB
+}
+
+// Insert suppresses an address, or records the latest reason when it is
+// suppressed already. A bounce also marks an account with the address that
+// has not been activated as undeliverable, since its owner can never receive
+// the activation token.
+func (m SuppressionModel) Insert(s *EmailSuppression) error {
+	query := `
+		INSERT INTO email_suppressions (email, reason, message_id, detail)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email) DO UPDATE
+		SET reason = EXCLUDED.reason, message_id = EXCLUDED.message_id, detail = EXCLUDED.detail
+		RETURNING id, created_at
+	`
+	args := []interface{}{s.Email, s.Reason, s.MessageID, s.Detail}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&s.ID, &s.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		if s.Reason != SuppressionBounce {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users
+			SET email_undeliverable = true, version = version + 1
+			WHERE email = $1 AND NOT activated AND NOT email_undeliverable`, s.Email)
+		return err
+	})
+}
+
+// Suppressed reports whether email is on the suppression list.
+func (m SuppressionModel) Suppressed(email string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM email_suppressions WHERE email = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var suppressed bool
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&suppressed)
+	return suppressed, err
+}
+
+// GetAll returns a page of suppressions whose address contains search,
+// optionally only those with the given reason.
+func (m SuppressionModel) GetAll(search, reason string, filters Filters) ([]*EmailSuppression, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, email, reason, message_id, detail
+		FROM email_suppressions
+		WHERE (email ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (reason = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, reason, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	suppressions := []*EmailSuppression{}
+	for rows.Next() {
+		var s EmailSuppression
+		err := rows.Scan(
+			&totalRecords,
+			&s.ID,
+			&s.CreatedAt,
+			&s.Email,
+			&s.Reason,
+			&s.MessageID,
+			&s.Detail,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		suppressions = append(suppressions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return suppressions, metadata, nil
+}
+
+// Delete takes an address off the suppression list and clears the
+// undeliverable mark of the account using it.
+func (m SuppressionModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var email string
+		err := tx.QueryRowContext(ctx, `DELETE FROM email_suppressions WHERE id = $1 RETURNING email`, id).Scan(&email)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users
+			SET email_undeliverable = false, version = version + 1
+			WHERE email = $1 AND email_undeliverable`, email)
+		return err
+	})
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/nimaposhtiban/greenlight/internal/validator"
+)
+
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+	// ScopeTwoFactor tokens stand in for the first factor of a sign-in that
+	// still needs a TOTP or recovery code.
+	ScopeTwoFactor = "two-factor"
+)
+
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
+}
+
+// Session describes an authentication token as shown to its owner.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
+}
+
+func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// New generates a token for the user and stores its hash.
+func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewSession generates an authentication token for the user and records the
+// client it was issued to.
+func (m TokenModel) NewSession(userID int64, ttl time.Duration, userAgent, ip string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+func insertToken(ctx context.Context, q dbtx, token *Token) error {
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP}
+
+	_, err := q.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return deleteTokensForUser(ctx, m.DB, scope, userID)
+}
+
+func deleteTokensForUser(ctx context.Context, q dbtx, scope string, userID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = $2
+	`
+
+	_, err := q.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// Touch records that an authentication token has just been used and returns
+// the ID of its session.
+func (m TokenModel) Touch(tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	UPDATE tokens
+	SET last_used_at = NOW()
+	WHERE hash = $1 AND scope = $2
+	RETURNING id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeAuthentication).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// GetSessions returns the unexpired authentication tokens of a user, most
+// recently used first. The session with currentID is flagged as current.
+func (m TokenModel) GetSessions(userID, currentID int64) ([]*Session, error) {
+	query := `
+	SELECT id, created_at, last_used_at, expiry, user_agent, ip
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND expiry > NOW()
+	ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentID
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes one authentication token of a user.
+func (m TokenModel) DeleteSession(userID, id int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE id = $1 AND user_id = $2 AND scope = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpired removes tokens of every scope that have expired and returns
+// how many there were.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE expiry <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,223 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTOTPEnrolled = errors.New("totp already enrolled")
+)
+
+// recoveryCodeCount is how many recovery codes are issued when two-factor
+// authentication is confirmed.
+const recoveryCodeCount = 10
+
+// TOTP is the two-factor authentication enrolment of a user. Enrolments only
+// protect logins once they are confirmed with a first code. LastStep is the
+// step of the last accepted code, so that no code is accepted twice.
+type TOTP struct {
+	UserID    int64
+	CreatedAt time.Time
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// GetForUser returns the enrolment of a user, or ErrRecordNotFound when the
+// user has not started one.
+func (m TOTPModel) GetForUser(userID int64) (*TOTP, error) {
+	query := `SELECT user_id, created_at, secret, confirmed, last_step
+	FROM users_totp
+	WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.CreatedAt,
+		&totp.Secret,
+		&totp.Confirmed,
+		&totp.LastStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &totp, nil
+}
+
+// Begin starts an enrolment with a new secret, replacing any unconfirmed
+// one. It returns ErrTOTPEnrolled when the user already has a confirmed
+// enrolment.
+func (m TOTPModel) Begin(totp *TOTP) error {
+	query := `INSERT INTO users_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, created_at = NOW(), last_step = 0
+	WHERE users_totp.confirmed = false
+	RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, totp.UserID, totp.Secret).Scan(&totp.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTOTPEnrolled
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Confirm marks the enrolment of a user as confirmed by a code of the given
+// step and returns a fresh set of recovery codes. Only the hashes of the
+// codes are stored.
+func (m TOTPModel) Confirm(userID, step int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		query := `UPDATE users_totp
+		SET confirmed = true, last_step = $2
+		WHERE user_id = $1 AND confirmed = false`
+
+		result, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrTOTPEnrolled
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		for _, code := range codes {
+			hash := sha256.Sum256([]byte(code))
+			_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash[:], userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseStep records that a code of the given step was accepted. It returns
+// false when a code of that step or a later one was already used.
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+	query := `UPDATE users_totp
+	SET last_step = $2
+	WHERE user_id = $1 AND last_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode spends one of the recovery codes of a user. It returns
+// false when the code is unknown or was already used.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	query := `UPDATE recovery_codes
+	SET used_at = NOW()
+	WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Delete removes the enrolment and the recovery codes of a user.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
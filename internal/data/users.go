@@ -2,60 +2,48 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
 	"errors"
+	"fmt"
 	"net/mail"
+	"regexp"
 	"time"
 
 	"github.com/nimaposhtiban/greenlight/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+var AnonymousUser = &User{}
+
+// DefaultLocale is the locale of users who did not choose one.
+const DefaultLocale = "en"
+
+var localeRX = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
 type UserModel struct {
 	DB *sql.DB
 }
 
 type User struct {
-	ID         int64     `json:"id"`
-	Created_at time.Time `json:"created_at"`
-	Name       string    `json:"name"`
-	Email      string    `json:"email"`
-	Password   password  `json:"-"`
-	Activated  bool      `json:"activated"`
-	Version    int       `json:"-"`
-}
-
-type password struct {
-	plainText *string
-	hash      []byte
-}
-
-func (p *password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
-	if err != nil {
-		return err
-	}
-
-	p.plainText = &plainTextPassword
-	p.hash = hash
-	return nil
+	ID                 int64     `json:"id"`
+	Created_at         time.Time `json:"created_at"`
+	Name               string    `json:"name"`
+	Email              string    `json:"email"`
+	Password           password  `json:"-"`
+	Activated          bool      `json:"activated"`
+	EmailUndeliverable bool      `json:"email_undeliverable"`
+	Locale             string    `json:"locale"`
+	Version            int       `json:"-"`
 }
 
-func (p *password) Matches(plainTextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainTextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-	return true, nil
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -66,7 +54,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlainText(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= maxPasswordLength, "password", fmt.Sprintf("must not be more than %d bytes long", maxPasswordLength))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -75,6 +63,8 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	ValidateEmail(v, user.Email)
 
+	v.Check(validator.Matches(user.Locale, localeRX), "locale", "must be a language code such as en or fa-IR")
+
 	if user.Password.plainText != nil {
 		ValidatePasswordPlainText(v, *user.Password.plainText)
 	}
@@ -90,20 +80,60 @@ func isValidEmail(email string) bool {
 }
 
 func (m UserModel) Insert(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+// Register inserts a new user together with an activation token and queues
+// the email carrying it, all in one transaction. The email gets the token as
+// activationToken and the user ID as userID.
+func (m UserModel) Register(user *User, activationTTL time.Duration, templateFile string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var token *Token
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := insertUser(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+
+		token, err = generateToken(user.ID, activationTTL, ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+		return enqueueEmail(ctx, tx, user.Email, user.Locale, templateFile, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func insertUser(ctx context.Context, q dbtx, user *User) error {
 	query := `
-	INSERT INTO users (name, email, password_hash, activated)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO users (name, email, password_hash, activated, locale)
+	VALUES ($1, $2, $3, $4, $5)
 	RETURNING id, created_at, version
 	`
 	args := []interface{}{
-		user.Name, user.Email, user.Password.hash, user.Activated,
+		user.Name, user.Email, user.Password.hash, user.Activated, user.Locale,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Created_at, &user.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Created_at, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -117,7 +147,7 @@ func (m UserModel) Insert(user *User) error {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, email_undeliverable, locale, version
 		FROM users
 		WHERE email = $1
 	`
@@ -131,6 +161,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.EmailUndeliverable,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -145,10 +177,112 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 }
 
 func (m UserModel) Update(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return updateUser(ctx, m.DB, user)
+}
+
+// ChangeEmail saves user after its email address has been changed, replaces
+// any outstanding activation tokens with a new one and queues the activation
+// email for the new address, all in one transaction.
+func (m UserModel) ChangeEmail(user *User, activationTTL time.Duration, templateFile string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var token *Token
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := updateUser(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+
+		err = deleteTokensForUser(ctx, tx, ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = generateToken(user.ID, activationTTL, ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+		return enqueueEmail(ctx, tx, user.Email, user.Locale, templateFile, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// SetActivated saves the activation state of user and records entry in the
+// audit log. Deactivating a user also revokes all of their tokens and API
+// keys, so that they are signed out everywhere.
+func (m UserModel) SetActivated(user *User, entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := updateUser(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+
+		if !user.Activated {
+			err = revokeAccess(ctx, tx, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return insertAudit(ctx, tx, entry)
+	})
+}
+
+// RevokeAccess deletes every token of the user, whatever its scope, and
+// every API key, and records entry in the audit log.
+func (m UserModel) RevokeAccess(userID int64, entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := revokeAccess(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return insertAudit(ctx, tx, entry)
+	})
+}
+
+func revokeAccess(ctx context.Context, q dbtx, userID int64) error {
+	for _, query := range []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+	} {
+		_, err := q.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateUser(ctx context.Context, q dbtx, user *User) error {
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, email_undeliverable = $5, locale = $6, version = version + 1
+		WHERE id = $7 AND version = $8
 		RETURNING version
 	`
 
@@ -157,14 +291,13 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.EmailUndeliverable,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := q.QueryRowContext(ctx, query, args...).Scan(
 		&user.Version,
 	)
 
@@ -182,3 +315,205 @@ func (m UserModel) Update(user *User) error {
 	return nil
 
 }
+
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, email_undeliverable, locale, version
+		FROM users
+		WHERE id = $1
+	`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Created_at,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.EmailUndeliverable,
+		&user.Locale,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// GetForToken returns the owner of an unexpired token with the given scope.
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.email_undeliverable, users.locale, users.version
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3
+	`
+	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.Created_at,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.EmailUndeliverable,
+		&user.Locale,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Anonymise scrubs the personal data of a user who deleted their account.
+// The row is kept so that their reviews still count towards movie ratings,
+// but the name, email address and password are replaced and their tokens,
+// API keys, linked identities, second factor, permissions, watchlist and
+// watch history are removed, as are the queued emails, bounce suppressions and
+// login failures recorded for the old address.
+func (m UserModel) Anonymise(user *User) error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(base32.StdEncoding.EncodeToString(randomBytes))
+	if err != nil {
+		return err
+	}
+
+	email := user.Email
+
+	user.Name = "Deleted user"
+	user.Email = fmt.Sprintf("deleted-%d@users.invalid", user.ID)
+	user.Activated = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+			WHERE id = $5 AND version = $6
+			RETURNING version
+		`
+		args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		for _, query := range []string{
+			`DELETE FROM tokens WHERE user_id = $1`,
+			`DELETE FROM watchlist_items WHERE user_id = $1`,
+			`DELETE FROM watch_events WHERE user_id = $1`,
+			`DELETE FROM api_keys WHERE user_id = $1`,
+			`DELETE FROM user_identities WHERE user_id = $1`,
+			`DELETE FROM recovery_codes WHERE user_id = $1`,
+			`DELETE FROM users_totp WHERE user_id = $1`,
+			`DELETE FROM users_permissions WHERE user_id = $1`,
+		} {
+			_, err = tx.ExecContext(ctx, query, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The old address is also kept by tables that are keyed on it rather
+		// than on the user.
+		for query, key := range map[string]string{
+			`DELETE FROM email_outbox WHERE lower(recipient) = lower($1)`: email,
+			`DELETE FROM email_suppressions WHERE email = $1`:             email,
+			`DELETE FROM login_failures WHERE key = $1`:                   AccountLoginKey(email),
+		} {
+			_, err = tx.ExecContext(ctx, query, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetAll returns a page of users whose name or email address contains search.
+// A nil activated matches both activated and inactive users.
+func (m UserModel) GetAll(search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, email_undeliverable, locale, version
+		FROM users
+		WHERE (name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (activated = $2 OR $2 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, activated, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.Created_at,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.EmailUndeliverable,
+			&user.Locale,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
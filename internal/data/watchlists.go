@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateWatchlistItem = errors.New("duplicate watchlist item")
+)
+
+// WatchlistItem is a movie a user saved to watch later. Position orders the
+// watchlist, starting at 1.
+type WatchlistItem struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+// WatchEvent records that a user watched a movie.
+type WatchEvent struct {
+	ID        int64     `json:"id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Movie     *Movie    `json:"movie"`
+}
+
+type WatchlistModel struct {
+	DB    *sql.DB
+	cache *recommendationCache
+}
+
+// Add appends a movie to the end of the user's watchlist.
+func (m WatchlistModel) Add(userID int64, movie *Movie) (*WatchlistItem, error) {
+	query := `INSERT INTO watchlist_items (user_id, movie_id, position)
+	SELECT $1, $2, COALESCE(max(position), 0) + 1
+	FROM watchlist_items
+	WHERE user_id = $1
+	RETURNING position, added_at`
+
+	item := &WatchlistItem{Movie: movie}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := lockWatchlist(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(ctx, query, userID, movie.ID).Scan(&item.Position, &item.AddedAt)
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Constraint == "watchlist_items_pkey":
+			return nil, ErrDuplicateWatchlistItem
+		case errors.As(err, &pqErr) && pqErr.Constraint == "watchlist_items_movie_id_fkey":
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return item, nil
+}
+
+// lockWatchlist serialises changes to the positions of a user's watchlist
+// until the end of the transaction. It locks the user's row rather than the
+// watchlist rows, which do not exist yet when the first movie is added.
+func lockWatchlist(ctx context.Context, q dbtx, userID int64) error {
+	_, err := q.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID)
+	return err
+}
+
+// Remove takes a movie off the user's watchlist and closes the gap it leaves
+// in the positions.
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return removeFromWatchlist(ctx, tx, userID, movieID)
+	})
+}
+
+func removeFromWatchlist(ctx context.Context, q dbtx, userID, movieID int64) error {
+	err := lockWatchlist(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = q.QueryRowContext(ctx, `DELETE FROM watchlist_items WHERE user_id = $1 AND movie_id = $2 RETURNING position`, userID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = q.ExecContext(ctx, `UPDATE watchlist_items SET position = position - 1 WHERE user_id = $1 AND position > $2`, userID, position)
+	return err
+}
+
+// Move places a movie at the given position of the user's watchlist, shifting
+// the movies in between. Positions past the end move the movie to the end.
+// It returns the position the movie ended up at.
+func (m WatchlistModel) Move(userID, movieID int64, position int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := lockWatchlist(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT movie_id, position FROM watchlist_items WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		count, current := 0, 0
+		for rows.Next() {
+			var id int64
+			var p int
+			if err := rows.Scan(&id, &p); err != nil {
+				return err
+			}
+			if id == movieID {
+				current = p
+			}
+			count++
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if current == 0 {
+			return ErrRecordNotFound
+		}
+		if position > count {
+			position = count
+		}
+
+		switch {
+		case position < current:
+			_, err = tx.ExecContext(ctx, `UPDATE watchlist_items SET position = position + 1 WHERE user_id = $1 AND position >= $2 AND position < $3`, userID, position, current)
+		case position > current:
+			_, err = tx.ExecContext(ctx, `UPDATE watchlist_items SET position = position - 1 WHERE user_id = $1 AND position > $2 AND position <= $3`, userID, current, position)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE watchlist_items SET position = $1 WHERE user_id = $2 AND movie_id = $3`, position, userID, movieID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return position, nil
+}
+
+// GetAll returns a page of the user's watchlist. Soft deleted movies are
+// left out.
+func (m WatchlistModel) GetAll(userID int64, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), w.position, w.added_at,
+		m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version, `+ratingColumns+`
+	FROM watchlist_items w
+	INNER JOIN movies m ON m.id = w.movie_id
+	LEFT JOIN movie_ratings r ON r.movie_id = m.id
+	WHERE w.user_id = $1 AND m.deleted_at IS NULL
+	ORDER BY %s %s, w.position ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WatchlistItem{}
+	for rows.Next() {
+		item := WatchlistItem{Movie: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&item.Position,
+			&item.AddedAt,
+			&item.Movie.ID,
+			&item.Movie.CreatedAt,
+			&item.Movie.Title,
+			&item.Movie.Year,
+			&item.Movie.Runtime,
+			pq.Array(&item.Movie.Genres),
+			&item.Movie.Version,
+			&item.Movie.AverageRating,
+			&item.Movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
+
+// MarkWatched adds a movie to the user's watch history and takes it off their
+// watchlist if it was on it.
+func (m WatchlistModel) MarkWatched(userID int64, event *WatchEvent) error {
+	query := `INSERT INTO watch_events (user_id, movie_id, watched_at)
+	VALUES ($1, $2, COALESCE($3, NOW()))
+	RETURNING id, watched_at`
+
+	var watchedAt *time.Time
+	if !event.WatchedAt.IsZero() {
+		watchedAt = &event.WatchedAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, userID, event.Movie.ID, watchedAt).Scan(&event.ID, &event.WatchedAt)
+		if err != nil {
+			return err
+		}
+
+		err = removeFromWatchlist(ctx, tx, userID, event.Movie.ID)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watched movies are left out of the user's recommendations.
+	m.cache.invalidateKey(userRecommendationKey(userID))
+	return nil
+}
+
+// DeleteEvent removes an entry from the user's watch history.
+func (m WatchlistModel) DeleteEvent(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM watch_events WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.cache.invalidateKey(userRecommendationKey(userID))
+	return nil
+}
+
+// GetHistory returns a page of the user's watch history. Soft deleted movies
+// are left out.
+func (m WatchlistModel) GetHistory(userID int64, filters Filters) ([]*WatchEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), e.id, e.watched_at,
+		m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version, `+ratingColumns+`
+	FROM watch_events e
+	INNER JOIN movies m ON m.id = e.movie_id
+	LEFT JOIN movie_ratings r ON r.movie_id = m.id
+	WHERE e.user_id = $1 AND m.deleted_at IS NULL
+	ORDER BY %s %s, e.id DESC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*WatchEvent{}
+	for rows.Next() {
+		event := WatchEvent{Movie: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.WatchedAt,
+			&event.Movie.ID,
+			&event.Movie.CreatedAt,
+			&event.Movie.Title,
+			&event.Movie.Year,
+			&event.Movie.Runtime,
+			pq.Array(&event.Movie.Genres),
+			&event.Movie.Version,
+			&event.Movie.AverageRating,
+			&event.Movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
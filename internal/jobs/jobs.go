@@ -0,0 +1,388 @@
+// Package jobs is a persistent background job queue backed by Postgres.
+//
+// Jobs are rows in the jobs table. Workers claim them with SELECT ... FOR
+// UPDATE SKIP LOCKED, so any number of workers in any number of processes can
+// share a queue. A job that fails is retried with exponential back-off until
+// its retry policy gives up, after which it stays in the table as failed.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nimaposhtiban/greenlight/internal/jsonlog"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var (
+	ErrUnknownKind = errors.New("unknown job kind")
+	ErrDuplicate   = errors.New("a job with this unique key is already queued or running")
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int             `json:"priority"`
+	UniqueKey   *string         `json:"unique_key,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Decode unmarshals the payload of the job into dst.
+func (j *Job) Decode(dst interface{}) error {
+	return json.Unmarshal(j.Payload, dst)
+}
+
+// Handler runs a job of one kind. An error, or a panic, counts as a failed
+// attempt.
+type Handler func(ctx context.Context, job *Job) error
+
+// RetryPolicy says how often a kind of job is attempted. The delay before a
+// retry starts at Backoff and doubles with every failure up to MaxBackoff,
+// half of it being random.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Options tune a single job. The zero value runs the job as soon as possible
+// with normal priority.
+type Options struct {
+	// Priority orders due jobs, higher first.
+	Priority int
+	// RunAt schedules the job for later.
+	RunAt time.Time
+	// UniqueKey, when set, keeps a second job with the same key from being
+	// queued while the first one is queued or running.
+	UniqueKey string
+}
+
+type registration struct {
+	handler Handler
+	policy  RetryPolicy
+}
+
+type Queue struct {
+	db           *sql.DB
+	logger       *jsonlog.Logger
+	timeout      time.Duration
+	pollInterval time.Duration
+	handlers     map[string]registration
+}
+
+// New returns a queue whose jobs may run for up to timeout each. Idle workers
+// look for due jobs every pollInterval.
+func New(db *sql.DB, logger *jsonlog.Logger, timeout, pollInterval time.Duration) *Queue {
+	return &Queue{
+		db:           db,
+		logger:       logger,
+		timeout:      timeout,
+		pollInterval: pollInterval,
+		handlers:     make(map[string]registration),
+	}
+}
+
+// Register sets the handler and retry policy of a kind of job. All kinds have
+// to be registered before the first worker starts.
+func (q *Queue) Register(kind string, policy RetryPolicy, handler Handler) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	q.handlers[kind] = registration{handler: handler, policy: policy}
+}
+
+// Enqueue adds a job of a registered kind with payload encoded as JSON.
+func (q *Queue) Enqueue(kind string, payload interface{}, opts Options) (*Job, error) {
+	reg, ok := q.handlers[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	var uniqueKey *string
+	if opts.UniqueKey != "" {
+		uniqueKey = &opts.UniqueKey
+	}
+
+	query := `
+	INSERT INTO jobs (kind, payload, priority, unique_key, max_attempts, run_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND status IN ('queued', 'running') DO NOTHING
+	RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	args := []interface{}{kind, js, opts.Priority, uniqueKey, reg.policy.MaxAttempts, runAt}
+	job, err := scanJob(q.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrDuplicate
+		default:
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// Work runs jobs one at a time until stop is closed. A job that is running
+// when stop is closed is finished first. Start as many workers as jobs
+// should run concurrently.
+func (q *Queue) Work(stop <-chan struct{}) {
+	for {
+		job, err := q.claim()
+		if err != nil {
+			q.logger.PrintError(err, nil)
+		}
+
+		if job != nil {
+			q.run(job)
+
+			select {
+			case <-stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// claim takes the most urgent due job of a registered kind and marks it as
+// running. Running jobs whose lease has run out, because their worker died,
+// are claimed again. It returns nil when there is nothing to do.
+func (q *Queue) claim() (*Job, error) {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	query := `
+	UPDATE jobs
+	SET status = 'running', attempts = attempts + 1, locked_until = NOW() + $2 * INTERVAL '1 millisecond'
+	WHERE id = (
+		SELECT id
+		FROM jobs
+		WHERE kind = ANY($1)
+		AND ((status = 'queued' AND run_at <= NOW()) OR (status = 'running' AND locked_until < NOW()))
+		ORDER BY priority DESC, run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// The lease outlasts the job timeout, so that a job is never claimed
+	// twice while its handler can still be running.
+	lease := q.timeout + time.Minute
+
+	job, err := scanJob(q.db.QueryRowContext(ctx, query, pq.Array(kinds), lease.Milliseconds()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+func (q *Queue) run(job *Job) {
+	reg := q.handlers[job.Kind]
+
+	err := q.call(reg.handler, job)
+	if err == nil {
+		err = q.finish(job, StatusDone, "", time.Time{})
+		if err != nil {
+			q.logger.PrintError(err, nil)
+		}
+		return
+	}
+
+	properties := map[string]string{
+		"job_id":   strconv.FormatInt(job.ID, 10),
+		"kind":     job.Kind,
+		"attempts": strconv.Itoa(job.Attempts),
+	}
+
+	status, runAt := StatusQueued, time.Now().Add(Backoff(job.Attempts, reg.policy.Backoff, reg.policy.MaxBackoff))
+	if job.Attempts >= job.MaxAttempts {
+		status = StatusFailed
+		properties["status"] = StatusFailed
+	}
+	q.logger.PrintError(err, properties)
+
+	err = q.finish(job, status, err.Error(), runAt)
+	if err != nil {
+		q.logger.PrintError(err, nil)
+	}
+}
+
+// call runs the handler with the job timeout, turning a panic into an error.
+func (q *Queue) call(handler Handler, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+
+	return handler(ctx, job)
+}
+
+func (q *Queue) finish(job *Job, status, lastError string, runAt time.Time) error {
+	query := `
+	UPDATE jobs
+	SET status = $1,
+		last_error = $2,
+		run_at = CASE WHEN $1 = 'queued' THEN $3 ELSE run_at END,
+		finished_at = CASE WHEN $1 IN ('done', 'failed') THEN NOW() END,
+		locked_until = NULL
+	WHERE id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := q.db.ExecContext(ctx, query, status, lastError, runAt, job.ID)
+	return err
+}
+
+// List returns a page of jobs in the given statuses, optionally of one kind,
+// in the order they are due, along with the total number of matches.
+func (q *Queue) List(statuses []string, kind string, limit, offset int) ([]*Job, int, error) {
+	query := `
+	SELECT count(*) OVER(), ` + jobColumns + `
+	FROM jobs
+	WHERE status = ANY($1)
+	AND (kind = $2 OR $2 = '')
+	ORDER BY priority DESC, run_at, id
+	LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx, query, pq.Array(statuses), kind, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+	for rows.Next() {
+		var job Job
+		err := rows.Scan(append([]interface{}{&totalRecords}, job.fields()...)...)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, totalRecords, nil
+}
+
+// DeleteFinished removes jobs that succeeded or failed for good more than
+// olderThan ago and returns how many there were.
+func (q *Queue) DeleteFinished(olderThan time.Duration) (int64, error) {
+	query := `
+	DELETE FROM jobs
+	WHERE status IN ('done', 'failed') AND finished_at < NOW() - $1 * INTERVAL '1 millisecond'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	result, err := q.db.ExecContext(ctx, query, olderThan.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+const jobColumns = `id, created_at, kind, payload, priority, unique_key, status, attempts, max_attempts, run_at, last_error, finished_at`
+
+func (j *Job) fields() []interface{} {
+	return []interface{}{
+		&j.ID,
+		&j.CreatedAt,
+		&j.Kind,
+		(*[]byte)(&j.Payload),
+		&j.Priority,
+		&j.UniqueKey,
+		&j.Status,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.RunAt,
+		&j.LastError,
+		&j.FinishedAt,
+	}
+}
+
+func scanJob(row *sql.Row) (*Job, error) {
+	var job Job
+	err := row.Scan(job.fields()...)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Backoff returns the delay before the next attempt after the given number
+// of failed ones. It doubles from base up to maxDelay, and half of it is
+// random so that jobs failing together are not retried together.
+func Backoff(attempts int, base, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempts >= 1 && attempts <= 32 {
+		if d := base << (attempts - 1); d > 0 && d < maxDelay {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DirMailer writes emails as .eml files into a directory instead of sending
+// them, for local development. The files open in any mail client.
+type DirMailer struct {
+	templates *Templates
+	dir       string
+	sender    string
+}
+
+// NewDir returns a mailer writing into dir, which is created if needed.
+func NewDir(templates *Templates, dir, sender string) (*DirMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirMailer{
+		templates: templates,
+		dir:       dir,
+		sender:    sender,
+	}, nil
+}
+
+func (m *DirMailer) Send(email *Email) error {
+	msg, err := prepare(m.templates, m.sender, email)
+	if err != nil {
+		return err
+	}
+
+	randomBytes := make([]byte, 4)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	// Names sort in the order the emails were sent.
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(randomBytes))
+
+	f, err := os.OpenFile(filepath.Join(m.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.mime().WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
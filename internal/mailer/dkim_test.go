@@ -0,0 +1,282 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The canonicalization example of RFC 6376 section 3.4.6.
+func TestRelaxedCanonicalization(t *testing.T) {
+	fields := parseHeader([]byte("A: X\r\nB : Y\t\r\n\tZ  "))
+
+	var got []string
+	for _, f := range fields {
+		got = append(got, relaxedHeader(f.name, f.value))
+	}
+	if strings.Join(got, "\r\n") != "a:X\r\nb:Y Z" {
+		t.Errorf("relaxed header = %q", strings.Join(got, "\r\n"))
+	}
+
+	body := relaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))
+	if string(body) != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", body)
+	}
+
+	if body := relaxedBody(nil); len(body) != 0 {
+		t.Errorf("relaxed empty body = %q", body)
+	}
+}
+
+func writeKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type dkimKey struct {
+	name   string
+	path   string
+	public crypto.PublicKey
+}
+
+func testKeys(t *testing.T) []dkimKey {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8RSA, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Ed, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []dkimKey{
+		{"rsa pkcs1", writeKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), &rsaKey.PublicKey},
+		{"rsa pkcs8", writeKey(t, "PRIVATE KEY", pkcs8RSA), &rsaKey.PublicKey},
+		{"ed25519", writeKey(t, "PRIVATE KEY", pkcs8Ed), edPublic},
+	}
+}
+
+// verifyDKIM checks the DKIM-Signature of message the way a receiving server
+// would, with the public key it would look up in DNS, and returns its tags.
+func verifyDKIM(message []byte, public crypto.PublicKey) (map[string]string, error) {
+	header, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return nil, errors.New("message has no body")
+	}
+
+	fields := parseHeader(header)
+	var signature *headerField
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, "DKIM-Signature") {
+			signature = &fields[i]
+		}
+	}
+	if signature == nil {
+		return nil, errors.New("message is not signed")
+	}
+
+	tags := map[string]string{}
+	for _, tag := range strings.Split(signature.value, ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+	}
+
+	if tags["c"] != "relaxed/relaxed" {
+		return nil, fmt.Errorf("canonicalization %q", tags["c"])
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return nil, errors.New("body hash does not match")
+	}
+
+	hashed := new(bytes.Buffer)
+	used := make([]bool, len(fields))
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, name) {
+				continue
+			}
+			used[i] = true
+			hashed.WriteString(relaxedHeader(fields[i].name, fields[i].value) + "\r\n")
+			break
+		}
+	}
+	// The signature header itself is hashed with an empty b= value.
+	unsigned := signature.value[:strings.LastIndex(signature.value, "b=")+2]
+	hashed.WriteString(relaxedHeader(signature.name, unsigned))
+	digest := sha256.Sum256(hashed.Bytes())
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return nil, fmt.Errorf("algorithm %q", tags["a"])
+		}
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+		if err != nil {
+			return nil, err
+		}
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return nil, fmt.Errorf("algorithm %q", tags["a"])
+		}
+		if !ed25519.Verify(key, digest[:], sig) {
+			return nil, errors.New("ed25519: verification error")
+		}
+	}
+
+	return tags, nil
+}
+
+func TestDKIMSignedOverSMTP(t *testing.T) {
+	for _, key := range testKeys(t) {
+		t.Run(key.name, func(t *testing.T) {
+			signer, err := LoadDKIMSigner(key.path, "example.com", "mail")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := newSMTPServer(t)
+			m := newTestSMTP(t, s, SMTPConfig{PoolSize: 1, IdleTimeout: time.Minute, DKIM: signer})
+
+			email := welcomeEmail("Alice <alice@example.com>")
+			email.ListUnsubscribe = "https://example.com/unsubscribe"
+			email.Attachments = []Attachment{{Name: "notes.txt", Data: []byte("line one  \r\nline two\t\r\n\r\n")}}
+			err = m.Send(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, messages := s.stats()
+			if len(messages) != 1 {
+				t.Fatalf("received %d emails, want 1", len(messages))
+			}
+
+			tags, err := verifyDKIM(messages[0].data, key.public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tags["d"] != "example.com" || tags["s"] != "mail" {
+				t.Errorf("signed for %s._domainkey.%s", tags["s"], tags["d"])
+			}
+			for _, name := range []string{"from", "to", "subject", "date", "message-id", "list-unsubscribe"} {
+				if !strings.Contains(":"+tags["h"]+":", ":"+name+":") {
+					t.Errorf("%s is not signed (h=%s)", name, tags["h"])
+				}
+			}
+		})
+	}
+}
+
+func TestDKIMDetectsTampering(t *testing.T) {
+	key := testKeys(t)[0]
+	signer, err := LoadDKIMSigner(key.path, "example.com", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\n\r\nHello\r\n")
+	signature, err := signer.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		old   string
+		new   string
+		valid bool
+	}{
+		{"unchanged", "", "", true},
+		{"refolded header", "Subject: Hi", "Subject:   Hi \r\n\t", true},
+		{"trailing whitespace", "Hello", "Hello \t", true},
+		{"changed subject", "Subject: Hi", "Subject: Ho", false},
+		{"changed recipient", "b@example.com", "c@example.com", false},
+		{"changed body", "Hello", "Hullo", false},
+	}
+
+	for _, tt := range tests {
+		changed := bytes.Replace(message, []byte(tt.old), []byte(tt.new), 1)
+		_, err := verifyDKIM(append([]byte(signature), changed...), key.public)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: signature still verifies", tt.name)
+		}
+	}
+}
+
+func TestLoadDKIMSignerErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	os.WriteFile(notPEM, []byte("not a key"), 0600)
+
+	valid := testKeys(t)[0].path
+
+	tests := []struct {
+		name     string
+		path     string
+		domain   string
+		selector string
+	}{
+		{"missing domain", valid, "", "mail"},
+		{"missing selector", valid, "example.com", ""},
+		{"missing file", filepath.Join(t.TempDir(), "nope.pem"), "example.com", "mail"},
+		{"not pem", notPEM, "example.com", "mail"},
+		{"ecdsa key", writeKey(t, "PRIVATE KEY", ecDER), "example.com", "mail"},
+		{"certificate", writeKey(t, "CERTIFICATE", []byte{1, 2, 3}), "example.com", "mail"},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadDKIMSigner(tt.path, tt.domain, tt.selector)
+		if err == nil {
+			t.Errorf("%s: LoadDKIMSigner succeeded", tt.name)
+		}
+	}
+}
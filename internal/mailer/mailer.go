@@ -2,64 +2,201 @@ package mailer
 
 import (
 	"bytes"
+	"crypto/rand"
 	"embed"
-	"html/template"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"path"
+	"strings"
 	"time"
 
-	"github.com/go-mail/mail/v2"
+	gomail "github.com/go-mail/mail/v2"
 )
 
 //go:embed "templates"
 var templateFS embed.FS
 
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+var ErrNoRecipients = errors.New("mailer: email has no recipients")
+
+// Mailer sends emails rendered from the templates in the templates
+// directory.
+type Mailer interface {
+	Send(email *Email) error
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = time.Second * 5
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+// Email describes an email to send. Template names a template that defines a
+// "subject", a "plainBody" and an "htmlBody", which are rendered with Data in
+// the variant for Locale.
+type Email struct {
+	To  []string
+	CC  []string
+	BCC []string
+
+	Locale   string
+	Template string
+	Data     interface{}
+
+	ReplyTo string
+	// ListUnsubscribe is a mailto: or https: URL recipients can use to
+	// unsubscribe. https URLs are announced as supporting one-click
+	// unsubscription.
+	ListUnsubscribe string
+	// MessageID identifies the email in logs and in replies. A bare ID such
+	// as "outbox.42" is completed with the sender's domain. When it is empty
+	// a random one is generated, and either way Send stores the final value
+	// here.
+	MessageID string
+	// Headers are set on the email as they are.
+	Headers map[string]string
+
+	Attachments []Attachment
+	// Inline files are referenced from the HTML body as cid:<Name>, e.g.
+	// <img src="cid:logo.png">.
+	Inline []Attachment
+}
+
+// Attachment is a file sent with an email. ContentType is guessed from the
+// name when it is empty.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// NewEmail returns an email of a template to a single recipient.
+func NewEmail(recipient, locale, templateFile string, data interface{}) *Email {
+	return &Email{
+		To:       []string{recipient},
+		Locale:   locale,
+		Template: templateFile,
+		Data:     data,
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
-	if err != nil {
-		return err
+// Message is a rendered email.
+type Message struct {
+	MessageID   string
+	From        string
+	To          []string
+	CC          []string
+	BCC         []string
+	ReplyTo     string
+	Headers     map[string]string
+	Subject     string
+	PlainBody   string
+	HTMLBody    string
+	Attachments []Attachment
+	Inline      []Attachment
+}
+
+// prepare renders an email from sender and fills in its message ID.
+func prepare(templates *Templates, sender string, email *Email) (*Message, error) {
+	if len(email.To)+len(email.CC)+len(email.BCC) == 0 {
+		return nil, ErrNoRecipients
 	}
 
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	msg, err := templates.render(email.Locale, email.Template, email.Data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	email.MessageID, err = messageID(email.MessageID, sender)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
-	if err != nil {
-		return err
+	headers := make(map[string]string, len(email.Headers)+2)
+	for k, v := range email.Headers {
+		headers[k] = v
+	}
+	if email.ListUnsubscribe != "" {
+		headers["List-Unsubscribe"] = "<" + email.ListUnsubscribe + ">"
+		if strings.HasPrefix(email.ListUnsubscribe, "https:") {
+			headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+		}
 	}
 
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	msg.MessageID = email.MessageID
+	msg.From = sender
+	msg.To = email.To
+	msg.CC = email.CC
+	msg.BCC = email.BCC
+	msg.ReplyTo = email.ReplyTo
+	msg.Headers = headers
+	msg.Attachments = email.Attachments
+	msg.Inline = email.Inline
+	return msg, nil
+}
 
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
-		return err
+// messageID completes id into a Message-ID header value, generating a random
+// one when id is empty.
+func messageID(id, sender string) (string, error) {
+	if strings.HasPrefix(id, "<") {
+		return id, nil
+	}
+
+	if id == "" {
+		randomBytes := make([]byte, 12)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return "", err
+		}
+		id = fmt.Sprintf("%d.%s", time.Now().Unix(), hex.EncodeToString(randomBytes))
+	}
+
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(sender); err == nil {
+		if _, d, found := strings.Cut(addr.Address, "@"); found {
+			domain = d
+		}
+	}
+
+	return "<" + id + "@" + domain + ">", nil
+}
+
+// mime builds the MIME message of a rendered email.
+func (m *Message) mime() *gomail.Message {
+	msg := gomail.NewMessage()
+	for k, v := range m.Headers {
+		msg.SetHeader(k, v)
+	}
+	msg.SetHeader("Message-ID", m.MessageID)
+	msg.SetHeader("From", m.From)
+	if len(m.To) > 0 {
+		msg.SetHeader("To", m.To...)
+	}
+	if len(m.CC) > 0 {
+		msg.SetHeader("Cc", m.CC...)
+	}
+	if len(m.BCC) > 0 {
+		msg.SetHeader("Bcc", m.BCC...)
+	}
+	if m.ReplyTo != "" {
+		msg.SetHeader("Reply-To", m.ReplyTo)
+	}
+	msg.SetHeader("Subject", m.Subject)
+	msg.SetBody("text/plain", m.PlainBody)
+	msg.AddAlternative("text/html", m.HTMLBody)
+
+	for _, a := range m.Attachments {
+		msg.AttachReader(a.Name, bytes.NewReader(a.Data), a.header())
+	}
+	for _, a := range m.Inline {
+		msg.EmbedReader(a.Name, bytes.NewReader(a.Data), a.header())
+	}
+	return msg
+}
+
+func (a Attachment) header() gomail.FileSetting {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(a.Name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
-	return nil
+	return gomail.SetHeader(map[string][]string{"Content-Type": {contentType}})
 }
@@ -0,0 +1,45 @@
+package mailer
+
+import "sync"
+
+// MemoryMailer records emails instead of sending them, so that tests can
+// check what would have been sent.
+type MemoryMailer struct {
+	mu        sync.Mutex
+	templates *Templates
+	sender    string
+	messages  []Message
+}
+
+func NewMemory(templates *Templates, sender string) *MemoryMailer {
+	return &MemoryMailer{templates: templates, sender: sender}
+}
+
+func (m *MemoryMailer) Send(email *Email) error {
+	msg, err := prepare(m.templates, m.sender, email)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+// Messages returns the emails recorded so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset forgets the recorded emails.
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
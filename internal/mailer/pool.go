@@ -0,0 +1,183 @@
+package mailer
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	gomail "github.com/go-mail/mail/v2"
+)
+
+// smtpPool keeps authenticated SMTP connections open between emails, so that
+// a batch of emails does not pay for a TCP, TLS and AUTH handshake each. At
+// most size connections are open at once. Idle connections are closed by a
+// reaper once they have been unused for idleTimeout.
+type smtpPool struct {
+	dialer      *gomail.Dialer
+	idleTimeout time.Duration
+	slots       chan struct{}
+	done        chan struct{}
+
+	mu     sync.Mutex
+	idle   []*smtpConn
+	closed bool
+}
+
+type smtpConn struct {
+	sender   gomail.SendCloser
+	lastUsed time.Time
+}
+
+func newSMTPPool(dialer *gomail.Dialer, size int, idleTimeout time.Duration) *smtpPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &smtpPool{
+		dialer:      dialer,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size),
+		done:        make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go p.reap()
+	}
+	return p
+}
+
+// reap closes idle connections that have timed out until the pool is closed.
+// Servers drop idle clients after a while anyway, but not before holding a
+// session open for nothing.
+func (p *smtpPool) reap() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*smtpConn
+		p.mu.Lock()
+		kept := p.idle[:0]
+		for _, c := range p.idle {
+			if time.Since(c.lastUsed) > p.idleTimeout {
+				expired = append(expired, c)
+				continue
+			}
+			kept = append(kept, c)
+		}
+		p.idle = kept
+		p.mu.Unlock()
+
+		for _, c := range expired {
+			c.sender.Close()
+		}
+	}
+}
+
+// send delivers msg over a pooled connection. A connection that fails is
+// closed rather than put back. When it had been idle in the pool, the server
+// may just have dropped it, so the email is tried once more on a new one.
+func (p *smtpPool) send(from string, to []string, msg io.WriterTo) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	conn, reused, err := p.get()
+	if err != nil {
+		return err
+	}
+
+	err = conn.sender.Send(from, to, msg)
+	if err != nil && reused {
+		conn.sender.Close()
+
+		conn, err = p.dial()
+		if err != nil {
+			return err
+		}
+		err = conn.sender.Send(from, to, msg)
+	}
+	if err != nil {
+		conn.sender.Close()
+		return err
+	}
+
+	p.put(conn)
+	return nil
+}
+
+// get returns the most recently used idle connection that has not timed out,
+// or a new one. Timed out connections the reaper has not got to yet are
+// closed on the way.
+func (p *smtpPool) get() (*smtpConn, bool, error) {
+	var expired []*smtpConn
+	var conn *smtpConn
+
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(c.lastUsed) > p.idleTimeout {
+			expired = append(expired, c)
+			continue
+		}
+		conn = c
+		break
+	}
+	p.mu.Unlock()
+
+	for _, c := range expired {
+		c.sender.Close()
+	}
+
+	if conn != nil {
+		return conn, true, nil
+	}
+
+	conn, err := p.dial()
+	return conn, false, err
+}
+
+func (p *smtpPool) dial() (*smtpConn, error) {
+	sender, err := p.dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return &smtpConn{sender: sender}, nil
+}
+
+func (p *smtpPool) put(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.sender.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// close closes the idle connections. Connections in use are closed when
+// their email has been sent.
+func (p *smtpPool) close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.done)
+
+	for _, c := range idle {
+		c.sender.Close()
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"time"
+
+	gomail "github.com/go-mail/mail/v2"
+)
+
+// SMTPConfig configures an SMTPMailer.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+
+	// PoolSize is the most connections kept open to the server and
+	// IdleTimeout how long an unused one is kept.
+	PoolSize    int
+	IdleTimeout time.Duration
+
+	// DKIM signs every email when set.
+	DKIM *DKIMSigner
+}
+
+// SMTPMailer sends emails through an SMTP server, reusing connections
+// between emails.
+type SMTPMailer struct {
+	templates *Templates
+	pool      *smtpPool
+	sender    string
+	dkim      *DKIMSigner
+}
+
+func NewSMTP(templates *Templates, cfg SMTPConfig) *SMTPMailer {
+	dialer := gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)
+	dialer.Timeout = time.Second * 5
+	return &SMTPMailer{
+		templates: templates,
+		pool:      newSMTPPool(dialer, cfg.PoolSize, cfg.IdleTimeout),
+		sender:    cfg.Sender,
+		dkim:      cfg.DKIM,
+	}
+}
+
+func (m *SMTPMailer) Send(email *Email) error {
+	msg, err := prepare(m.templates, m.sender, email)
+	if err != nil {
+		return err
+	}
+
+	raw := new(bytes.Buffer)
+	_, err = msg.mime().WriteTo(raw)
+	if err != nil {
+		return err
+	}
+
+	signature := ""
+	if m.dkim != nil {
+		signature, err = m.dkim.Sign(raw.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	recipients := []string{}
+	for _, list := range [][]string{msg.To, msg.CC, msg.BCC} {
+		for _, recipient := range list {
+			recipients = append(recipients, envelopeAddress(recipient))
+		}
+	}
+
+	return m.pool.send(envelopeAddress(msg.From), recipients, signedMessage{signature, raw.Bytes()})
+}
+
+// Close closes the idle connections to the server.
+func (m *SMTPMailer) Close() error {
+	return m.pool.close()
+}
+
+// envelopeAddress returns the bare address of a header address such as
+// "Greenlight <no-reply@example.com>".
+func envelopeAddress(address string) string {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return addr.Address
+}
+
+// signedMessage writes a rendered message behind its DKIM-Signature header.
+type signedMessage struct {
+	signature string
+	raw       []byte
+}
+
+func (s signedMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, s.signature)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(s.raw)
+	return int64(n + m), err
+}
@@ -0,0 +1,313 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal in-process SMTP server that accepts every email
+// and records it.
+type smtpServer struct {
+	ln   net.Listener
+	host string
+	port int
+
+	mu       sync.Mutex
+	dials    int
+	closed   int
+	messages []receivedMessage
+	// hangUp makes the server close a connection after each email, like a
+	// server dropping an idle client.
+	hangUp bool
+}
+
+type receivedMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &smtpServer{ln: ln, host: addr.IP.String(), port: addr.Port}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.dials++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		s.closed++
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP test")
+
+	var msg receivedMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			msg = receivedMessage{from: address(line)}
+			reply("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, address(line))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.Bytes()
+
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			hangUp := s.hangUp
+			s.mu.Unlock()
+
+			reply("250 OK")
+			if hangUp {
+				return
+			}
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) stats() (dials, closed int, messages []receivedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials, s.closed, append([]receivedMessage(nil), s.messages...)
+}
+
+// address returns the address between angle brackets of a MAIL or RCPT
+// command.
+func address(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func newTestSMTP(t *testing.T, s *smtpServer, cfg SMTPConfig) *SMTPMailer {
+	t.Helper()
+
+	templates, err := LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Host = s.host
+	cfg.Port = s.port
+	cfg.Username = "greenlight"
+	cfg.Password = "secret"
+	cfg.Sender = "Greenlight <no-reply@example.com>"
+
+	m := NewSMTP(templates, cfg)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func welcomeEmail(recipient string) *Email {
+	return NewEmail(recipient, "", "user_welcome.tmpl", map[string]interface{}{
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"userID":          1,
+	})
+}
+
+func TestSMTPReusesConnections(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestSMTP(t, s, SMTPConfig{PoolSize: 2, IdleTimeout: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		email := welcomeEmail("Alice <alice@example.com>")
+		email.BCC = []string{"audit@example.com"}
+		err := m.Send(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dials, _, messages := s.stats()
+	if dials != 1 {
+		t.Errorf("dialled %d times, want 1", dials)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("received %d emails, want 3", len(messages))
+	}
+
+	msg := messages[0]
+	if msg.from != "no-reply@example.com" {
+		t.Errorf("envelope sender %q", msg.from)
+	}
+	if strings.Join(msg.to, ",") != "alice@example.com,audit@example.com" {
+		t.Errorf("envelope recipients %q", msg.to)
+	}
+	if bytes.Contains(msg.data, []byte("audit@example.com")) {
+		t.Error("Bcc recipient is visible in the message")
+	}
+	if !bytes.Contains(msg.data, []byte("Y3QMGX3PJ3WLRL2YRTQGQ6KRHU")) {
+		t.Error("message does not contain the rendered template")
+	}
+}
+
+func TestSMTPBoundsConnections(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestSMTP(t, s, SMTPConfig{PoolSize: 2, IdleTimeout: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := m.Send(welcomeEmail("alice@example.com"))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	dials, _, messages := s.stats()
+	if dials > 2 {
+		t.Errorf("dialled %d times, want at most 2", dials)
+	}
+	if len(messages) != 10 {
+		t.Errorf("received %d emails, want 10", len(messages))
+	}
+}
+
+func TestSMTPReconnectsAfterDroppedConnection(t *testing.T) {
+	s := newSMTPServer(t)
+	s.hangUp = true
+	m := newTestSMTP(t, s, SMTPConfig{PoolSize: 1, IdleTimeout: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		err := m.Send(welcomeEmail("alice@example.com"))
+		if err != nil {
+			t.Fatalf("email %d: %v", i, err)
+		}
+		// Let the server finish hanging up before the next email.
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	dials, _, messages := s.stats()
+	if len(messages) != 3 {
+		t.Errorf("received %d emails, want 3", len(messages))
+	}
+	if dials != 3 {
+		t.Errorf("dialled %d times, want 3", dials)
+	}
+}
+
+func TestSMTPClosesIdleConnections(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestSMTP(t, s, SMTPConfig{PoolSize: 1, IdleTimeout: 50 * time.Millisecond})
+
+	err := m.Send(welcomeEmail("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, closed, _ := s.stats()
+		if closed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle connection was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = m.Send(welcomeEmail("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dials, _, _ := s.stats(); dials != 2 {
+		t.Errorf("dialled %d times, want 2", dials)
+	}
+}
+
+func TestSMTPClose(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestSMTP(t, s, SMTPConfig{PoolSize: 1, IdleTimeout: time.Minute})
+
+	err := m.Send(welcomeEmail("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, closed, _ := s.stats(); closed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Close left the idle connection open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// DefaultLocale is the locale of the templates without a locale in their
+// name. Every localised variant needs one of those to fall back on.
+const DefaultLocale = "en"
+
+var requiredBlocks = []string{"subject", "plainBody", "htmlBody"}
+
+// Templates holds every email template, parsed once together with the
+// shared layouts in templates/layouts. A template named user_welcome.tmpl may
+// have localised variants such as user_welcome.fa.tmpl or
+// user_welcome.pt-BR.tmpl.
+type Templates struct {
+	sets map[string]*template.Template
+}
+
+// LoadTemplates parses the embedded templates. It fails when a template does
+// not define a subject, plainBody and htmlBody, or when a localised variant
+// has no default to fall back on.
+func LoadTemplates() (*Templates, error) {
+	return loadTemplates(templateFS)
+}
+
+func loadTemplates(fsys fs.FS) (*Templates, error) {
+	layouts, err := fs.Glob(fsys, "templates/layouts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(fsys, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{sets: make(map[string]*template.Template)}
+
+	for _, file := range files {
+		// The template itself is parsed last, so that it can redefine blocks
+		// of the layouts.
+		patterns := append(append([]string{}, layouts...), file)
+
+		tmpl, err := template.New("email").ParseFS(fsys, patterns...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, block := range requiredBlocks {
+			if tmpl.Lookup(block) == nil {
+				return nil, fmt.Errorf("mailer: %s does not define %q", file, block)
+			}
+		}
+
+		t.sets[strings.TrimSuffix(path.Base(file), ".tmpl")] = tmpl
+	}
+
+	for name := range t.sets {
+		base, _, localised := strings.Cut(name, ".")
+		if _, ok := t.sets[base]; localised && !ok {
+			return nil, fmt.Errorf("mailer: %s.tmpl has no default %s.tmpl", name, base)
+		}
+	}
+
+	return t, nil
+}
+
+// lookup returns the variant of a template that best matches the locale:
+// first the exact locale, then its language and finally the default.
+func (t *Templates) lookup(templateFile, locale string) (*template.Template, error) {
+	name := strings.TrimSuffix(templateFile, ".tmpl")
+
+	candidates := []string{}
+	if locale != "" && locale != DefaultLocale {
+		candidates = append(candidates, name+"."+locale)
+		if language, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, name+"."+language)
+		}
+	}
+	candidates = append(candidates, name)
+
+	for _, candidate := range candidates {
+		if tmpl, ok := t.sets[candidate]; ok {
+			return tmpl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("mailer: no template %s", templateFile)
+}
+
+// render renders the subject and bodies of the template variant for the
+// locale.
+func (t *Templates) render(locale, templateFile string, data interface{}) (*Message, error) {
+	tmpl, err := t.lookup(templateFile, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
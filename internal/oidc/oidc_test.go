@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockProvider is an OpenID provider serving discovery, JWKS and a token
+// endpoint that returns idToken for the code "good-code".
+type mockProvider struct {
+	*httptest.Server
+	key        *rsa.PrivateKey
+	idToken    string
+	jwksHits   atomic.Int32
+	tokenForms chan url.Values
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{key: key, tokenForms: make(chan url.Values, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.jwksHits.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.tokenForms <- r.PostForm
+		if r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) client() *Client {
+	return New(Config{
+		Issuer:       p.URL,
+		ClientID:     "greenlight",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/callback",
+	}, p.Client())
+}
+
+// claims returns valid claims for the client and nonce "n-1".
+func (p *mockProvider) claims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            p.URL,
+		"sub":            "user-1",
+		"aud":            "greenlight",
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "n-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+func (p *mockProvider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestExchange(t *testing.T) {
+	p := newMockProvider(t)
+	c := p.client()
+	p.idToken = p.sign(t, "key-1", p.claims())
+
+	claims, err := c.Exchange(context.Background(), "good-code", "verifier", "n-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	form := <-p.tokenForms
+	if form.Get("code_verifier") != "verifier" || form.Get("grant_type") != "authorization_code" {
+		t.Errorf("unexpected token request %v", form)
+	}
+
+	_, err = c.Exchange(context.Background(), "bad-code", "verifier", "n-1")
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Exchange with a refused code = %v, want a token endpoint error", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	p := newMockProvider(t)
+
+	tests := []struct {
+		name   string
+		modify func(map[string]interface{})
+		token  func(string) string
+		nonce  string
+	}{
+		{name: "wrong audience", modify: func(c map[string]interface{}) { c["aud"] = "someone-else" }},
+		{name: "wrong issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "issued in the future", modify: func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "missing subject", modify: func(c map[string]interface{}) { delete(c, "sub") }},
+		{name: "nonce mismatch", nonce: "n-2"},
+		{name: "bad signature", token: func(s string) string {
+			parts := strings.Split(s, ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			signature[0] ^= 0xff
+			return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+		}},
+		{name: "tampered payload", token: func(s string) string {
+			parts := strings.Split(s, ".")
+			forged, _ := json.Marshal(map[string]interface{}{"iss": p.URL, "sub": "admin", "aud": "greenlight", "nonce": "n-1", "exp": time.Now().Add(time.Hour).Unix()})
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+		}},
+		{name: "malformed", token: func(string) string { return "not-a-jwt" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := p.claims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			token := p.sign(t, "key-1", claims)
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+			nonce := "n-1"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			_, err := p.client().Verify(context.Background(), token, nonce)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAcceptsAudienceList(t *testing.T) {
+	p := newMockProvider(t)
+
+	claims := p.claims()
+	claims["aud"] = []string{"other", "greenlight"}
+
+	_, err := p.client().Verify(context.Background(), p.sign(t, "key-1", claims), "n-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownKeyRefreshIsLimited(t *testing.T) {
+	p := newMockProvider(t)
+	c := p.client()
+
+	for i := 0; i < 5; i++ {
+		_, err := c.Verify(context.Background(), p.sign(t, "made-up", p.claims()), "n-1")
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify with unknown kid = %v, want ErrInvalidToken", err)
+		}
+	}
+	if hits := p.jwksHits.Load(); hits != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", hits)
+	}
+
+	// Known keys keep working from the cache.
+	_, err := c.Verify(context.Background(), p.sign(t, "key-1", p.claims()), "n-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits := p.jwksHits.Load(); hits != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", hits)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newMockProvider(t)
+
+	verifier, challenge, err := GeneratePKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatal("challenge is not the S256 of the verifier")
+	}
+
+	raw, err := p.client().AuthCodeURL(context.Background(), "s-1", "n-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("state") != "s-1" || q.Get("nonce") != "n-1" ||
+		q.Get("code_challenge") != challenge || q.Get("code_challenge_method") != "S256" ||
+		q.Get("client_id") != "greenlight" || q.Get("response_type") != "code" {
+		t.Errorf("unexpected authorization URL %s", raw)
+	}
+}
@@ -0,0 +1,140 @@
+package totp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The HOTP values of RFC 4226 Appendix D.
+func TestHOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, code := range want {
+		got := HOTP(key, uint64(counter), 6, sha1.New)
+		if got != code {
+			t.Errorf("HOTP(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// The TOTP values of RFC 6238 Appendix B.
+func TestTOTPVectors(t *testing.T) {
+	keys := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	hashes := map[string]func() hash.Hash{
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA512": sha512.New,
+	}
+
+	tests := []struct {
+		unix int64
+		mode string
+		code string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		got := HOTP(keys[tt.mode], uint64(step), 8, hashes[tt.mode])
+		if got != tt.code {
+			t.Errorf("%s at %d = %s, want %s", tt.mode, tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeAndVerify(t *testing.T) {
+	// base32 of the RFC 6238 SHA-1 key.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "050471" {
+		t.Fatalf("Code = %s, want 050471", code)
+	}
+
+	step, ok := Verify(secret, code, now)
+	if !ok || step != Step(now) {
+		t.Fatalf("Verify(current code) = %d, %v", step, ok)
+	}
+
+	// Lower case and spaced secrets, as typed from an authenticator app.
+	_, ok = Verify(strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"), code, now)
+	if !ok {
+		t.Error("Verify rejected a lower case secret with spaces")
+	}
+
+	for _, offset := range []time.Duration{-Period, Period} {
+		if _, ok := Verify(secret, code, now.Add(offset)); !ok {
+			t.Errorf("Verify rejected a code %v away", offset)
+		}
+	}
+	for _, offset := range []time.Duration{-2 * Period, 2 * Period} {
+		if _, ok := Verify(secret, code, now.Add(offset)); ok {
+			t.Errorf("Verify accepted a code %v away", offset)
+		}
+	}
+
+	for _, bad := range []string{"", "12345", "1234567", "000000"} {
+		if _, ok := Verify(secret, bad, now); ok {
+			t.Errorf("Verify accepted %q", bad)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Greenlight", "alice@example.com", "JBSWY3DPEHPK3PXP")
+
+	want := "otpauth://totp/Greenlight:alice@example.com?algorithm=SHA1&digits=6&issuer=Greenlight&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != want {
+		t.Errorf("URI = %s, want %s", uri, want)
+	}
+}
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    change_type text NOT NULL CHECK (change_type IN ('insert', 'update', 'delete', 'restore', 'rollback')),
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    deleted_at timestamp(0) with time zone,
+    PRIMARY KEY (movie_id, version)
+);
+
+INSERT INTO movie_revisions (movie_id, version, change_type, created_at, title, year, runtime, genres, deleted_at)
+SELECT id, version,
+    CASE
+        WHEN deleted_at IS NOT NULL THEN 'delete'
+        WHEN version = 1 THEN 'insert'
+        ELSE 'update'
+    END,
+    created_at, title, year, runtime, genres, deleted_at
+FROM movies
+ON CONFLICT (movie_id, version) DO NOTHING;
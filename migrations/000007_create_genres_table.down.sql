@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS genres;
+
+DROP FUNCTION IF EXISTS genre_slug(text);
@@ -0,0 +1,49 @@
+-- genre_slug must stay in step with data.Slugify.
+CREATE OR REPLACE FUNCTION genre_slug(text) RETURNS text
+LANGUAGE sql IMMUTABLE STRICT
+AS $$ SELECT trim(both '-' from regexp_replace(lower($1), '[^a-z0-9]+', '-', 'g')) $$;
+
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text UNIQUE NOT NULL,
+    name text UNIQUE NOT NULL,
+    aliases text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Every spelling already used by a movie is grouped by its slug. The most
+-- common spelling of each group becomes the canonical name and the others are
+-- kept as aliases.
+WITH spellings AS (
+    SELECT g AS spelling, genre_slug(g) AS slug, count(*) AS uses
+    FROM movies, unnest(genres) AS g
+    GROUP BY g
+), ranked AS (
+    SELECT spelling, slug, row_number() OVER (PARTITION BY slug ORDER BY uses DESC, spelling) AS rank
+    FROM spellings
+    WHERE slug <> ''
+)
+INSERT INTO genres (slug, name, aliases)
+SELECT slug,
+    min(spelling) FILTER (WHERE rank = 1),
+    coalesce(array_agg(spelling ORDER BY spelling) FILTER (WHERE rank > 1), '{}')
+FROM ranked
+GROUP BY slug
+ON CONFLICT DO NOTHING;
+
+-- Rewrite the movies to the canonical names, keeping the original order and
+-- dropping the duplicates the mapping produces.
+UPDATE movies m
+SET genres = mapped.genres
+FROM (
+    SELECT x.id, array_agg(g.name ORDER BY x.position) AS genres
+    FROM (
+        SELECT m.id, genre_slug(u.genre) AS slug, min(u.position) AS position
+        FROM movies m, unnest(m.genres) WITH ORDINALITY AS u(genre, position)
+        GROUP BY m.id, genre_slug(u.genre)
+    ) x
+    JOIN genres g ON g.slug = x.slug
+    GROUP BY x.id
+) mapped
+WHERE m.id = mapped.id AND m.genres <> mapped.genres;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score smallint NOT NULL CHECK (score BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_idx ON reviews (movie_id);
+
+-- movie_ratings is a running summary of reviews, updated in the same
+-- transaction as every review change.
+CREATE TABLE IF NOT EXISTS movie_ratings (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    rating_sum bigint NOT NULL DEFAULT 0,
+    rating_count integer NOT NULL DEFAULT 0
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS watch_events;
+
+DROP TABLE IF EXISTS watchlist_items;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS audit_log;
+
+DROP TABLE IF EXISTS users_permissions;
+
+DROP TABLE IF EXISTS permissions;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES ('users:admin')
+ON CONFLICT (code) DO NOTHING;
+
+-- The first administrator has to be granted by hand, e.g.
+-- INSERT INTO users_permissions SELECT id, (SELECT id FROM permissions WHERE code = 'users:admin') FROM users WHERE email = '...';
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    target_user_id bigint REFERENCES users ON DELETE SET NULL,
+    details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log (target_user_id);
@@ -0,0 +1,8 @@
+-- login_failures counts recent failed authentication attempts per key, where
+-- a key is either an email address ("email:...") or a client IP ("ip:...").
+CREATE TABLE IF NOT EXISTS login_failures (
+    key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone
+);
@@ -0,0 +1,7 @@
+ALTER TABLE tokens ADD COLUMN id bigserial UNIQUE;
+ALTER TABLE tokens ADD COLUMN created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE tokens ADD COLUMN user_agent text NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN ip text NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN last_used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
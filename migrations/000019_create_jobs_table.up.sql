@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT 'null',
+    priority integer NOT NULL DEFAULT 0,
+    unique_key text,
+    status text NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 1,
+    run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone,
+    last_error text NOT NULL DEFAULT '',
+    finished_at timestamp(0) with time zone
+);
+
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'done', 'failed'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE unique_key IS NOT NULL AND status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (priority DESC, run_at, id) WHERE status IN ('queued', 'running');
+
+INSERT INTO permissions (code)
+VALUES ('jobs:admin')
+ON CONFLICT (code) DO NOTHING;
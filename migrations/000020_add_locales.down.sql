@@ -0,0 +1,3 @@
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS locale;
+
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN locale text NOT NULL DEFAULT 'en';
+
+ALTER TABLE email_outbox ADD COLUMN locale text NOT NULL DEFAULT 'en';
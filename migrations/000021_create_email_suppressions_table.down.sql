@@ -0,0 +1,9 @@
+DELETE FROM permissions WHERE code = 'emails:admin';
+
+UPDATE email_outbox SET status = 'dead' WHERE status = 'suppressed';
+ALTER TABLE email_outbox DROP CONSTRAINT email_outbox_status_check;
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_status_check CHECK (status IN ('pending', 'sent', 'dead'));
+
+ALTER TABLE users DROP COLUMN IF EXISTS email_undeliverable;
+
+DROP TABLE IF EXISTS email_suppressions;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    email citext UNIQUE NOT NULL,
+    reason text NOT NULL,
+    message_id text NOT NULL DEFAULT '',
+    detail text NOT NULL DEFAULT ''
+);
+
+ALTER TABLE email_suppressions ADD CONSTRAINT email_suppressions_reason_check CHECK (reason IN ('bounce', 'complaint'));
+
+ALTER TABLE users ADD COLUMN email_undeliverable bool NOT NULL DEFAULT false;
+
+ALTER TABLE email_outbox DROP CONSTRAINT email_outbox_status_check;
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_status_check CHECK (status IN ('pending', 'sent', 'dead', 'suppressed'));
+
+INSERT INTO permissions (code)
+VALUES ('emails:admin')
+ON CONFLICT (code) DO NOTHING;
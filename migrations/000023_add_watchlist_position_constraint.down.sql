@@ -0,0 +1 @@
+ALTER TABLE watchlist_items DROP CONSTRAINT IF EXISTS watchlist_items_user_id_position_key;
@@ -0,0 +1,13 @@
+-- Renumber positions that concurrent adds may have duplicated before the
+-- constraint makes them impossible.
+UPDATE watchlist_items w
+SET position = ranked.position
+FROM (
+    SELECT user_id, movie_id, row_number() OVER (PARTITION BY user_id ORDER BY position, added_at, movie_id) AS position
+    FROM watchlist_items
+) ranked
+WHERE w.user_id = ranked.user_id AND w.movie_id = ranked.movie_id AND w.position <> ranked.position;
+
+-- Moving an item shifts its neighbours one statement at a time, so positions
+-- are only required to be unique once the transaction commits.
+ALTER TABLE watchlist_items ADD CONSTRAINT watchlist_items_user_id_position_key UNIQUE (user_id, position) DEFERRABLE INITIALLY DEFERRED;
@@ -0,0 +1,3 @@
+INSERT INTO permissions (code)
+VALUES ('genres:admin')
+ON CONFLICT (code) DO NOTHING;
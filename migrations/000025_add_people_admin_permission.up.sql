@@ -0,0 +1,3 @@
+INSERT INTO permissions (code)
+VALUES ('people:admin')
+ON CONFLICT (code) DO NOTHING;